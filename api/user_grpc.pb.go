@@ -27,6 +27,15 @@ type UserStoreClient interface {
 	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*UpdateUserReply, error)
 	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*DeleteUserReply, error)
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (UserStore_ListUsersClient, error)
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateReply, error)
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserReply, error)
+	ListUsersPage(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersPageReply, error)
+	RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*RestoreUserReply, error)
+	PurgeUser(ctx context.Context, in *PurgeUserRequest, opts ...grpc.CallOption) (*PurgeUserReply, error)
+	BatchAddUsers(ctx context.Context, in *BatchAddUsersRequest, opts ...grpc.CallOption) (*BatchAddUsersReply, error)
+	BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUpdateUsersReply, error)
+	BatchDeleteUsers(ctx context.Context, in *BatchDeleteUsersRequest, opts ...grpc.CallOption) (*BatchDeleteUsersReply, error)
+	ImportUsers(ctx context.Context, opts ...grpc.CallOption) (UserStore_ImportUsersClient, error)
 }
 
 type userStoreClient struct {
@@ -105,6 +114,112 @@ func (x *userStoreListUsersClient) Recv() (*User, error) {
 	return m, nil
 }
 
+func (c *userStoreClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateReply, error) {
+	out := new(AuthenticateReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/Authenticate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*GetUserReply, error) {
+	out := new(GetUserReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) ListUsersPage(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersPageReply, error) {
+	out := new(ListUsersPageReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/ListUsersPage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*RestoreUserReply, error) {
+	out := new(RestoreUserReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/RestoreUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) PurgeUser(ctx context.Context, in *PurgeUserRequest, opts ...grpc.CallOption) (*PurgeUserReply, error) {
+	out := new(PurgeUserReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/PurgeUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) BatchAddUsers(ctx context.Context, in *BatchAddUsersRequest, opts ...grpc.CallOption) (*BatchAddUsersReply, error) {
+	out := new(BatchAddUsersReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/BatchAddUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUpdateUsersReply, error) {
+	out := new(BatchUpdateUsersReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/BatchUpdateUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) BatchDeleteUsers(ctx context.Context, in *BatchDeleteUsersRequest, opts ...grpc.CallOption) (*BatchDeleteUsersReply, error) {
+	out := new(BatchDeleteUsersReply)
+	err := c.cc.Invoke(ctx, "/api.UserStore/BatchDeleteUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userStoreClient) ImportUsers(ctx context.Context, opts ...grpc.CallOption) (UserStore_ImportUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserStore_ServiceDesc.Streams[1], "/api.UserStore/ImportUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userStoreImportUsersClient{stream}
+	return x, nil
+}
+
+type UserStore_ImportUsersClient interface {
+	Send(*AddUserRequest) error
+	CloseAndRecv() (*ImportSummary, error)
+	grpc.ClientStream
+}
+
+type userStoreImportUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *userStoreImportUsersClient) Send(m *AddUserRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *userStoreImportUsersClient) CloseAndRecv() (*ImportSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // UserStoreServer is the server API for UserStore service.
 // All implementations must embed UnimplementedUserStoreServer
 // for forward compatibility
@@ -114,6 +229,15 @@ type UserStoreServer interface {
 	UpdateUser(context.Context, *UpdateUserRequest) (*UpdateUserReply, error)
 	DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserReply, error)
 	ListUsers(*ListUsersRequest, UserStore_ListUsersServer) error
+	Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateReply, error)
+	GetUser(context.Context, *GetUserRequest) (*GetUserReply, error)
+	ListUsersPage(context.Context, *ListUsersRequest) (*ListUsersPageReply, error)
+	RestoreUser(context.Context, *RestoreUserRequest) (*RestoreUserReply, error)
+	PurgeUser(context.Context, *PurgeUserRequest) (*PurgeUserReply, error)
+	BatchAddUsers(context.Context, *BatchAddUsersRequest) (*BatchAddUsersReply, error)
+	BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUpdateUsersReply, error)
+	BatchDeleteUsers(context.Context, *BatchDeleteUsersRequest) (*BatchDeleteUsersReply, error)
+	ImportUsers(UserStore_ImportUsersServer) error
 	mustEmbedUnimplementedUserStoreServer()
 }
 
@@ -136,6 +260,33 @@ func (UnimplementedUserStoreServer) DeleteUser(context.Context, *DeleteUserReque
 func (UnimplementedUserStoreServer) ListUsers(*ListUsersRequest, UserStore_ListUsersServer) error {
 	return status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
+func (UnimplementedUserStoreServer) Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
+func (UnimplementedUserStoreServer) GetUser(context.Context, *GetUserRequest) (*GetUserReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUserStoreServer) ListUsersPage(context.Context, *ListUsersRequest) (*ListUsersPageReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsersPage not implemented")
+}
+func (UnimplementedUserStoreServer) RestoreUser(context.Context, *RestoreUserRequest) (*RestoreUserReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreUser not implemented")
+}
+func (UnimplementedUserStoreServer) PurgeUser(context.Context, *PurgeUserRequest) (*PurgeUserReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeUser not implemented")
+}
+func (UnimplementedUserStoreServer) BatchAddUsers(context.Context, *BatchAddUsersRequest) (*BatchAddUsersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchAddUsers not implemented")
+}
+func (UnimplementedUserStoreServer) BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUpdateUsersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchUpdateUsers not implemented")
+}
+func (UnimplementedUserStoreServer) BatchDeleteUsers(context.Context, *BatchDeleteUsersRequest) (*BatchDeleteUsersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchDeleteUsers not implemented")
+}
+func (UnimplementedUserStoreServer) ImportUsers(UserStore_ImportUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportUsers not implemented")
+}
 func (UnimplementedUserStoreServer) mustEmbedUnimplementedUserStoreServer() {}
 
 // UnsafeUserStoreServer may be embedded to opt out of forward compatibility for this service.
@@ -242,6 +393,176 @@ func (x *userStoreListUsersServer) Send(m *User) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _UserStore_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/Authenticate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_ListUsersPage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).ListUsersPage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/ListUsersPage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).ListUsersPage(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_RestoreUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).RestoreUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/RestoreUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).RestoreUser(ctx, req.(*RestoreUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_PurgeUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).PurgeUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/PurgeUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).PurgeUser(ctx, req.(*PurgeUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_BatchAddUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchAddUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).BatchAddUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/BatchAddUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).BatchAddUsers(ctx, req.(*BatchAddUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_BatchUpdateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchUpdateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).BatchUpdateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/BatchUpdateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).BatchUpdateUsers(ctx, req.(*BatchUpdateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_BatchDeleteUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDeleteUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserStoreServer).BatchDeleteUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.UserStore/BatchDeleteUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserStoreServer).BatchDeleteUsers(ctx, req.(*BatchDeleteUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserStore_ImportUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(UserStoreServer).ImportUsers(&userStoreImportUsersServer{stream})
+}
+
+type UserStore_ImportUsersServer interface {
+	SendAndClose(*ImportSummary) error
+	Recv() (*AddUserRequest, error)
+	grpc.ServerStream
+}
+
+type userStoreImportUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *userStoreImportUsersServer) SendAndClose(m *ImportSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *userStoreImportUsersServer) Recv() (*AddUserRequest, error) {
+	m := new(AddUserRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // UserStore_ServiceDesc is the grpc.ServiceDesc for UserStore service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -265,6 +586,38 @@ var UserStore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _UserStore_DeleteUser_Handler,
 		},
+		{
+			MethodName: "Authenticate",
+			Handler:    _UserStore_Authenticate_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _UserStore_GetUser_Handler,
+		},
+		{
+			MethodName: "ListUsersPage",
+			Handler:    _UserStore_ListUsersPage_Handler,
+		},
+		{
+			MethodName: "RestoreUser",
+			Handler:    _UserStore_RestoreUser_Handler,
+		},
+		{
+			MethodName: "PurgeUser",
+			Handler:    _UserStore_PurgeUser_Handler,
+		},
+		{
+			MethodName: "BatchAddUsers",
+			Handler:    _UserStore_BatchAddUsers_Handler,
+		},
+		{
+			MethodName: "BatchUpdateUsers",
+			Handler:    _UserStore_BatchUpdateUsers_Handler,
+		},
+		{
+			MethodName: "BatchDeleteUsers",
+			Handler:    _UserStore_BatchDeleteUsers_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -272,6 +625,205 @@ var UserStore_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _UserStore_ListUsers_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ImportUsers",
+			Handler:       _UserStore_ImportUsers_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api/user.proto",
+}
+
+// DeadLetterAdminClient is the client API for DeadLetterAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DeadLetterAdminClient interface {
+	ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersReply, error)
+	GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*GetDeadLetterReply, error)
+	ReplayDeadLetters(ctx context.Context, in *ReplayDeadLettersRequest, opts ...grpc.CallOption) (*ReplayDeadLettersReply, error)
+	DiscardDeadLetters(ctx context.Context, in *DiscardDeadLettersRequest, opts ...grpc.CallOption) (*DiscardDeadLettersReply, error)
+}
+
+type deadLetterAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeadLetterAdminClient(cc grpc.ClientConnInterface) DeadLetterAdminClient {
+	return &deadLetterAdminClient{cc}
+}
+
+func (c *deadLetterAdminClient) ListDeadLetters(ctx context.Context, in *ListDeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersReply, error) {
+	out := new(ListDeadLettersReply)
+	err := c.cc.Invoke(ctx, "/api.DeadLetterAdmin/ListDeadLetters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deadLetterAdminClient) GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*GetDeadLetterReply, error) {
+	out := new(GetDeadLetterReply)
+	err := c.cc.Invoke(ctx, "/api.DeadLetterAdmin/GetDeadLetter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deadLetterAdminClient) ReplayDeadLetters(ctx context.Context, in *ReplayDeadLettersRequest, opts ...grpc.CallOption) (*ReplayDeadLettersReply, error) {
+	out := new(ReplayDeadLettersReply)
+	err := c.cc.Invoke(ctx, "/api.DeadLetterAdmin/ReplayDeadLetters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deadLetterAdminClient) DiscardDeadLetters(ctx context.Context, in *DiscardDeadLettersRequest, opts ...grpc.CallOption) (*DiscardDeadLettersReply, error) {
+	out := new(DiscardDeadLettersReply)
+	err := c.cc.Invoke(ctx, "/api.DeadLetterAdmin/DiscardDeadLetters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeadLetterAdminServer is the server API for DeadLetterAdmin service.
+// All implementations must embed UnimplementedDeadLetterAdminServer
+// for forward compatibility
+type DeadLetterAdminServer interface {
+	ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersReply, error)
+	GetDeadLetter(context.Context, *GetDeadLetterRequest) (*GetDeadLetterReply, error)
+	ReplayDeadLetters(context.Context, *ReplayDeadLettersRequest) (*ReplayDeadLettersReply, error)
+	DiscardDeadLetters(context.Context, *DiscardDeadLettersRequest) (*DiscardDeadLettersReply, error)
+	mustEmbedUnimplementedDeadLetterAdminServer()
+}
+
+// UnimplementedDeadLetterAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedDeadLetterAdminServer struct {
+}
+
+func (UnimplementedDeadLetterAdminServer) ListDeadLetters(context.Context, *ListDeadLettersRequest) (*ListDeadLettersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedDeadLetterAdminServer) GetDeadLetter(context.Context, *GetDeadLetterRequest) (*GetDeadLetterReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeadLetter not implemented")
+}
+func (UnimplementedDeadLetterAdminServer) ReplayDeadLetters(context.Context, *ReplayDeadLettersRequest) (*ReplayDeadLettersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayDeadLetters not implemented")
+}
+func (UnimplementedDeadLetterAdminServer) DiscardDeadLetters(context.Context, *DiscardDeadLettersRequest) (*DiscardDeadLettersReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DiscardDeadLetters not implemented")
+}
+func (UnimplementedDeadLetterAdminServer) mustEmbedUnimplementedDeadLetterAdminServer() {}
+
+// UnsafeDeadLetterAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeadLetterAdminServer will
+// result in compilation errors.
+type UnsafeDeadLetterAdminServer interface {
+	mustEmbedUnimplementedDeadLetterAdminServer()
+}
+
+func RegisterDeadLetterAdminServer(s grpc.ServiceRegistrar, srv DeadLetterAdminServer) {
+	s.RegisterService(&DeadLetterAdmin_ServiceDesc, srv)
+}
+
+func _DeadLetterAdmin_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeadLetterAdminServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.DeadLetterAdmin/ListDeadLetters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeadLetterAdminServer).ListDeadLetters(ctx, req.(*ListDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeadLetterAdmin_GetDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeadLetterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeadLetterAdminServer).GetDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.DeadLetterAdmin/GetDeadLetter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeadLetterAdminServer).GetDeadLetter(ctx, req.(*GetDeadLetterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeadLetterAdmin_ReplayDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplayDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeadLetterAdminServer).ReplayDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.DeadLetterAdmin/ReplayDeadLetters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeadLetterAdminServer).ReplayDeadLetters(ctx, req.(*ReplayDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeadLetterAdmin_DiscardDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DiscardDeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeadLetterAdminServer).DiscardDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.DeadLetterAdmin/DiscardDeadLetters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeadLetterAdminServer).DiscardDeadLetters(ctx, req.(*DiscardDeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DeadLetterAdmin_ServiceDesc is the grpc.ServiceDesc for DeadLetterAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DeadLetterAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "api.DeadLetterAdmin",
+	HandlerType: (*DeadLetterAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _DeadLetterAdmin_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "GetDeadLetter",
+			Handler:    _DeadLetterAdmin_GetDeadLetter_Handler,
+		},
+		{
+			MethodName: "ReplayDeadLetters",
+			Handler:    _DeadLetterAdmin_ReplayDeadLetters_Handler,
+		},
+		{
+			MethodName: "DiscardDeadLetters",
+			Handler:    _DeadLetterAdmin_DiscardDeadLetters_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/user.proto",
 }
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gorm.io/gorm"
+)
+
+// Stable ErrorInfo reasons of Authenticate failures.
+const (
+	ReasonInvalidCredentials = "INVALID_CREDENTIALS"
+	ReasonRateLimited        = "RATE_LIMITED"
+)
+
+// AuthPolicy controls the brute force protection of UserStore.Authenticate.
+type AuthPolicy struct {
+	// MaxFailedAttempts is the number of consecutive failed attempts after which an account gets locked.
+	// A non-positive value disables the lockout.
+	MaxFailedAttempts int
+	// LockoutDuration is how long a locked account rejects any attempt.
+	LockoutDuration time.Duration
+	// RateLimit is the max number of attempts for a single login within RateLimitWindow, whether the login exists or
+	// not. A non-positive value disables the rate limiting.
+	RateLimit       int
+	RateLimitWindow time.Duration
+}
+
+func DefaultAuthPolicy() AuthPolicy {
+	return AuthPolicy{
+		//nolint
+		MaxFailedAttempts: 5,
+		//nolint
+		LockoutDuration: 15 * time.Minute,
+		//nolint
+		RateLimit:       10,
+		RateLimitWindow: time.Minute,
+	}
+}
+
+// WithAuthPolicy sets the brute force protection of Authenticate, DefaultAuthPolicy() is used otherwise.
+func WithAuthPolicy(policy AuthPolicy) UserStoreOption {
+	return func(s *UserStore) {
+		s.authPolicy = policy
+	}
+}
+
+func (s *UserStore) Authenticate(ctx context.Context, req *api.AuthenticateRequest) (*api.AuthenticateReply, error) {
+	var column, login string
+	switch l := req.Login.(type) {
+	case *api.AuthenticateRequest_Email:
+		column, login = "email", strings.ToLower(l.Email)
+	case *api.AuthenticateRequest_Nickname:
+		column, login = "nickname", l.Nickname
+	}
+	if login == "" {
+		return nil, invalidArgumentError("login", "missing or empty 'email' or 'nickname' field")
+	}
+	if req.Password == "" {
+		return nil, invalidArgumentError("password", "missing or empty 'password' field")
+	}
+
+	if allowed, retryAfter := s.authLimiter.Allow(column + ":" + login); !allowed {
+		return nil, authFailure(codes.ResourceExhausted, ReasonRateLimited, "too many authentication attempts", retryAfter)
+	}
+
+	user, err := s.findUser(ctx, column, login)
+	if err != nil && !errors.Is(err, errAmbiguousNickname) {
+		return nil, s.internalError(ctx, err, "select query in Authenticate func")
+	}
+	// a nickname shared by several users can't tell which password to check, it fails like an unknown login without
+	// locking any of them.
+	if user == nil {
+		// Verify against a dummy hash anyway, so unknown logins take as long as wrong passwords.
+		_, _, _ = s.hasher.Verify(req.Password, s.dummyPasswordHash())
+
+		return nil, authFailure(codes.Unauthenticated, ReasonInvalidCredentials, "invalid credentials", 0)
+	}
+
+	now := time.Now()
+	if user.LockedUntil != nil && user.LockedUntil.After(now) || user.Password == "" {
+		// Locked accounts fail like wrong passwords, unknown logins never being locked: a distinct error would tell
+		// which logins exist after a few wrong guesses. So do users without a password, which can't authenticate,
+		// taking as long and without counting as failed attempts.
+		_, _, _ = s.hasher.Verify(req.Password, s.dummyPasswordHash())
+
+		return nil, authFailure(codes.Unauthenticated, ReasonInvalidCredentials, "invalid credentials", 0)
+	}
+
+	ok, needsRehash, err := s.hasher.Verify(req.Password, user.Password)
+	if err != nil {
+		return nil, s.internalError(ctx, err, "verifying password of user "+user.ID+" in Authenticate func")
+	}
+
+	if !ok {
+		if err := s.recordFailedLogin(ctx, user.ID, now); err != nil {
+			return nil, s.internalError(ctx, err, "update query in Authenticate func")
+		}
+
+		return nil, authFailure(codes.Unauthenticated, ReasonInvalidCredentials, "invalid credentials", 0)
+	}
+
+	patches := map[string]any{}
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		patches["failed_logins"] = 0
+		patches["locked_until"] = nil
+	}
+	if needsRehash {
+		// Transparently upgrade the stored hash to the current algorithm and parameters.
+		hash, err := s.hasher.Hash(req.Password)
+		if err != nil {
+			s.lg.Err(err).Str("id", user.ID).Msg("rehashing password in Authenticate func")
+		} else {
+			patches["password"] = hash
+		}
+	}
+	if len(patches) != 0 {
+		// UpdateColumns doesn't touch updated_at, as these aren't changes made by the user.
+		tx := s.db.WithContext(ctx).Model(&User{}).Where("id = ? AND password = ?", user.ID, user.Password).
+			UpdateColumns(patches)
+		if tx.Error != nil {
+			s.lg.Err(tx.Error).Msg("update query in Authenticate func")
+		}
+	}
+
+	return &api.AuthenticateReply{Id: user.ID}, nil
+}
+
+// recordFailedLogin atomically increments the failed logins counter of a user, and locks the account once the counter
+// reaches the policy threshold. The counter starts over once the account gets locked.
+func (s *UserStore) recordFailedLogin(ctx context.Context, id string, now time.Time) error {
+	patches := map[string]any{
+		"failed_logins": gorm.Expr("failed_logins + 1"),
+	}
+	if s.authPolicy.MaxFailedAttempts > 0 {
+		patches["failed_logins"] = gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END",
+			s.authPolicy.MaxFailedAttempts)
+		patches["locked_until"] = gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END",
+			s.authPolicy.MaxFailedAttempts, now.Add(s.authPolicy.LockoutDuration))
+	}
+
+	return s.db.WithContext(ctx).Model(&User{}).Where("id = ?", id).UpdateColumns(patches).Error
+}
+
+// dummyPasswordHash returns a hash produced by the current algorithm, to spend the same verification time on unknown
+// logins as on existing ones.
+func (s *UserStore) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.hasher.Hash("dummy password")
+	})
+
+	return s.dummyHash
+}
+
+func authFailure(code codes.Code, reason string, msg string, retryAfter time.Duration) error {
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain}
+	if retryAfter > 0 {
+		return statusWithDetails(code, msg, info, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	}
+
+	return statusWithDetails(code, msg, info)
+}
@@ -0,0 +1,233 @@
+package app_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+
+	return ""
+}
+
+func TestUserStore_Authenticate(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithPasswordHasher(makeTestHasher()))
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Nickname:  "nick",
+		Email:     "me@example.com",
+		Password:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		req        *api.AuthenticateRequest
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{
+			name: "valid_email",
+			req: &api.AuthenticateRequest{
+				Login:    &api.AuthenticateRequest_Email{Email: "Me@Example.com"},
+				Password: "secret",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "valid_nickname",
+			req: &api.AuthenticateRequest{
+				Login:    &api.AuthenticateRequest_Nickname{Nickname: "nick"},
+				Password: "secret",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "wrong_password",
+			req: &api.AuthenticateRequest{
+				Login:    &api.AuthenticateRequest_Email{Email: "me@example.com"},
+				Password: "wrong",
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: app.ReasonInvalidCredentials,
+		},
+		{
+			name: "unknown_login",
+			req: &api.AuthenticateRequest{
+				Login:    &api.AuthenticateRequest_Nickname{Nickname: "unknown"},
+				Password: "secret",
+			},
+			wantCode:   codes.Unauthenticated,
+			wantReason: app.ReasonInvalidCredentials,
+		},
+		{
+			name: "missing_login",
+			req: &api.AuthenticateRequest{
+				Password: "secret",
+			},
+			wantCode:   codes.InvalidArgument,
+			wantReason: app.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := s.Authenticate(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Authenticate() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+			if reason := errorReason(err); reason != tt.wantReason {
+				t.Errorf("Authenticate() reason = %q, want %q", reason, tt.wantReason)
+			}
+			if err == nil && reply.Id != added.Id {
+				t.Errorf("Authenticate() id = %s, want %s", reply.Id, added.Id)
+			}
+		})
+	}
+}
+
+func TestUserStore_Authenticate_Lockout(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{},
+		app.WithPasswordHasher(makeTestHasher()),
+		app.WithAuthPolicy(app.AuthPolicy{MaxFailedAttempts: 3, LockoutDuration: time.Hour}),
+	)
+
+	_, err = s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Email:     "me@example.com",
+		Password:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	login := &api.AuthenticateRequest_Email{Email: "me@example.com"}
+	for i := 0; i < 3; i++ {
+		_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{Login: login, Password: "wrong"})
+		if errorReason(err) != app.ReasonInvalidCredentials {
+			t.Fatalf("Authenticate() attempt %d: unexpected error: %v", i, err)
+		}
+	}
+
+	// even the right password is rejected once the account is locked, like for unknown logins.
+	_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{Login: login, Password: "secret"})
+	if status.Code(err) != codes.Unauthenticated || errorReason(err) != app.ReasonInvalidCredentials {
+		t.Errorf("Authenticate() on locked account: unexpected error: %v", err)
+	}
+}
+
+func TestUserStore_Authenticate_NoPassword(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{},
+		app.WithPasswordHasher(makeTestHasher()),
+		app.WithAuthPolicy(app.AuthPolicy{MaxFailedAttempts: 3, LockoutDuration: time.Hour}),
+	)
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Email:     "me@example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	// users without a password fail like unknown logins, without counting as failed attempts.
+	login := &api.AuthenticateRequest_Email{Email: "me@example.com"}
+	for i := 0; i < 3; i++ {
+		_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{Login: login, Password: "wrong"})
+		if status.Code(err) != codes.Unauthenticated || errorReason(err) != app.ReasonInvalidCredentials {
+			t.Fatalf("Authenticate() attempt %d: unexpected error: %v", i, err)
+		}
+	}
+	var user app.User
+	if err = db.First(&user, "id = ?", added.Id).Error; err != nil {
+		t.Fatalf("select user: %v", err)
+	}
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("Authenticate() without password counted failed logins: %d, locked until %v", user.FailedLogins,
+			user.LockedUntil)
+	}
+}
+
+func TestUserStore_Authenticate_RateLimit(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{},
+		app.WithPasswordHasher(makeTestHasher()),
+		app.WithAuthPolicy(app.AuthPolicy{RateLimit: 2, RateLimitWindow: time.Hour}),
+	)
+
+	login := &api.AuthenticateRequest_Nickname{Nickname: "nick"}
+	for i := 0; i < 2; i++ {
+		_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{Login: login, Password: "secret"})
+		if errorReason(err) != app.ReasonInvalidCredentials {
+			t.Fatalf("Authenticate() attempt %d: unexpected error: %v", i, err)
+		}
+	}
+
+	_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{Login: login, Password: "secret"})
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != app.ReasonRateLimited {
+		t.Errorf("Authenticate() over the rate limit: unexpected error: %v", err)
+	}
+}
+
+func TestUserStore_Authenticate_Rehash(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+
+	legacyHasher := app.NewPasswordHasher(app.NewBcryptAlgorithm(bcrypt.MinCost))
+	legacyHash, _ := legacyHasher.Hash("secret")
+	db.Create(&app.User{ID: "1", Email: "me@example.com", Password: legacyHash})
+
+	hasher := makeTestHasher()
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithPasswordHasher(hasher))
+
+	_, err = s.Authenticate(context.Background(), &api.AuthenticateRequest{
+		Login:    &api.AuthenticateRequest_Email{Email: "me@example.com"},
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Authenticate() unexpected error: %v", err)
+	}
+
+	stored := app.User{}
+	db.First(&stored, "id = ?", "1")
+	if ok, needsRehash, _ := hasher.Verify("secret", stored.Password); !ok || needsRehash {
+		t.Errorf("Authenticate() didn't upgrade the legacy hash: %q", stored.Password)
+	}
+}
@@ -0,0 +1,288 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBatchSize = 100
+
+	batchItemSavepoint = "batch_item"
+)
+
+// batchApplyFunc applies a prepared batch item within the batch transaction, and returns the affected user.
+type batchApplyFunc func(tx *gorm.DB) (*User, error)
+
+// batchPrepareFunc validates the batch item at index i, and returns the function applying it. It's called out of the
+// batch transaction, so the costly work like password hashing is done there.
+type batchPrepareFunc func(i int) (batchApplyFunc, error)
+
+func (s *UserStore) BatchAddUsers(ctx context.Context, req *api.BatchAddUsersRequest) (*api.BatchAddUsersReply, error) {
+	reply := &api.BatchAddUsersReply{}
+	call, replayed, err := s.idempotentCall(ctx, "BatchAddUsers", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+
+	prepare := func(i int) (batchApplyFunc, error) {
+		if req.Requests[i].IdempotencyKey != "" {
+			return nil, itemIdempotencyKeyError()
+		}
+		newUser, err := s.newUser(ctx, req.Requests[i])
+		if err != nil {
+			return nil, err
+		}
+
+		return func(tx *gorm.DB) (*User, error) {
+			return newUser, s.insertUser(ctx, tx, newUser)
+		}, nil
+	}
+	err = s.runBatch(ctx, call, reply, &reply.Results, req.Mode, len(req.Requests), AddNotification, prepare)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (s *UserStore) BatchUpdateUsers(
+	ctx context.Context, req *api.BatchUpdateUsersRequest,
+) (*api.BatchUpdateUsersReply, error) {
+	reply := &api.BatchUpdateUsersReply{}
+	call, replayed, err := s.idempotentCall(ctx, "BatchUpdateUsers", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+
+	prepare := func(i int) (batchApplyFunc, error) {
+		item := req.Requests[i]
+		if item.IdempotencyKey != "" {
+			return nil, itemIdempotencyKeyError()
+		}
+		patches, err := s.userPatches(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(tx *gorm.DB) (*User, error) {
+			return s.patchUser(ctx, tx, item.Id, item.ExpectedVersion, patches)
+		}, nil
+	}
+	err = s.runBatch(ctx, call, reply, &reply.Results, req.Mode, len(req.Requests), UpdateNotification, prepare)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (s *UserStore) BatchDeleteUsers(
+	ctx context.Context, req *api.BatchDeleteUsersRequest,
+) (*api.BatchDeleteUsersReply, error) {
+	reply := &api.BatchDeleteUsersReply{}
+	call, replayed, err := s.idempotentCall(ctx, "BatchDeleteUsers", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+
+	prepare := func(i int) (batchApplyFunc, error) {
+		if req.Requests[i].IdempotencyKey != "" {
+			return nil, itemIdempotencyKeyError()
+		}
+
+		return func(tx *gorm.DB) (*User, error) {
+			return s.deleteUser(ctx, tx, req.Requests[i])
+		}, nil
+	}
+	err = s.runBatch(ctx, call, reply, &reply.Results, req.Mode, len(req.Requests), DeleteNotification, prepare)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// runBatch prepares and applies count batch items in a single transaction, and notifies the changes once it's
+// committed. The item results are set in replyResults, the results field of reply, which is stored under the
+// idempotency key of call, if any. Internal errors always fail the whole batch.
+func (s *UserStore) runBatch(
+	ctx context.Context, call *idempotentCall, reply proto.Message, replyResults *[]*api.BatchItemResult,
+	mode api.BatchMode, count int, typ NotificationType, prepare batchPrepareFunc,
+) error {
+	if count > maxBatchSize {
+		return invalidArgumentError("requests", fmt.Sprintf("batches hold at most %d items", maxBatchSize))
+	}
+	perItem := mode == api.BatchMode_PER_ITEM
+
+	results := make([]*api.BatchItemResult, count)
+	applies := make([]batchApplyFunc, count)
+	for i := range applies {
+		apply, err := prepare(i)
+		if err != nil {
+			if !perItem || isInternal(err) {
+				return batchItemError(i, err)
+			}
+			results[i] = batchItemResult(i, nil, err)
+
+			continue
+		}
+		applies[i] = apply
+	}
+
+	var users []*User
+	replayed, err := call.transaction(ctx, "batch transaction in runBatch func", reply, func(tx *gorm.DB) error {
+		var itemErrs []error
+		var failed int
+		var err error
+		users, itemErrs, failed, err = s.applyBatchItems(ctx, tx, applies, perItem)
+		if err != nil {
+			return batchItemError(failed, err)
+		}
+		for i, user := range users {
+			switch {
+			case user != nil:
+				results[i] = batchItemResult(i, user, nil)
+			case itemErrs[i] != nil:
+				results[i] = batchItemResult(i, nil, itemErrs[i])
+			}
+		}
+		*replyResults = results
+
+		return s.enqueueNotifications(ctx, tx, typ, appliedUsers(users)...)
+	})
+	if err != nil || replayed {
+		return err
+	}
+
+	s.notifyCommitted(typ, appliedUsers(users)...)
+
+	return nil
+}
+
+// applyBatchItems applies the non nil items within tx, and returns the users of the applied items along with the
+// errors of the failed ones, by item index. In PER_ITEM mode, each item is applied within a savepoint, so that a
+// failing item can be rolled back alone, postgres aborting the whole transaction otherwise. Otherwise, or on internal
+// errors, the first failing item fails the whole batch: its index and error are then returned.
+func (s *UserStore) applyBatchItems(
+	ctx context.Context, tx *gorm.DB, applies []batchApplyFunc, perItem bool,
+) ([]*User, []error, int, error) {
+	users := make([]*User, len(applies))
+	itemErrs := make([]error, len(applies))
+	for i, apply := range applies {
+		if apply == nil {
+			continue
+		}
+		if perItem {
+			if err := tx.SavePoint(batchItemSavepoint).Error; err != nil {
+				return nil, nil, i, s.internalError(ctx, err, "savepoint query in applyBatchItems func")
+			}
+		}
+
+		user, err := apply(tx)
+		if err != nil {
+			if !perItem || isInternal(err) {
+				return nil, nil, i, err
+			}
+			if err := tx.RollbackTo(batchItemSavepoint).Error; err != nil {
+				return nil, nil, i, s.internalError(ctx, err, "rollback to savepoint query in applyBatchItems func")
+			}
+			itemErrs[i] = err
+
+			continue
+		}
+		users[i] = user
+	}
+
+	return users, itemErrs, 0, nil
+}
+
+// appliedUsers returns the users of the applied batch items, skipping the failed ones.
+func appliedUsers(users []*User) []*User {
+	applied := make([]*User, 0, len(users))
+	for _, user := range users {
+		if user != nil {
+			applied = append(applied, user)
+		}
+	}
+
+	return applied
+}
+
+// isInternal reports whether err is an error of the service rather than of the request.
+func isInternal(err error) bool {
+	switch status.Code(err) {
+	case codes.Internal, codes.Unavailable, codes.Unknown, codes.Canceled, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func batchItemResult(index int, user *User, err error) *api.BatchItemResult {
+	//nolint
+	result := &api.BatchItemResult{Index: int32(index)}
+	if err == nil {
+		result.User = toAPIUser(user)
+
+		return result
+	}
+
+	st := status.Convert(err)
+	result.Code, result.Message = int32(st.Code()), st.Message()
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			result.Reason = info.Reason
+		}
+	}
+
+	return result
+}
+
+// batchItemError returns the error of the batch item at index, which fails the whole batch: it's the error of the item
+// with its index in the message, in the ErrorInfo metadata and in the BadRequest field paths.
+func batchItemError(index int, err error) error {
+	st := status.Convert(err)
+	prefix := "requests[" + strconv.Itoa(index) + "]"
+
+	details := make([]protoiface.MessageV1, 0, len(st.Details()))
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			info := proto.Clone(d).(*errdetails.ErrorInfo)
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata["index"] = strconv.Itoa(index)
+			details = append(details, info)
+		case *errdetails.BadRequest:
+			badRequest := proto.Clone(d).(*errdetails.BadRequest)
+			for _, v := range badRequest.FieldViolations {
+				v.Field = prefix + "." + v.Field
+			}
+			details = append(details, badRequest)
+		case protoiface.MessageV1:
+			details = append(details, d)
+		}
+	}
+
+	return statusWithDetails(st.Code(), prefix+": "+st.Message(), details...)
+}
@@ -0,0 +1,179 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func resultCodes(results []*api.BatchItemResult) []codes.Code {
+	var got []codes.Code
+	for _, r := range results {
+		got = append(got, codes.Code(r.Code))
+	}
+
+	return got
+}
+
+func countUsers(t *testing.T, s *app.UserStore) int {
+	t.Helper()
+
+	page, err := s.ListUsersPage(context.Background(), &api.ListUsersRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error on call list users page: %v", err)
+	}
+
+	return int(page.TotalCount)
+}
+
+func TestUserStore_BatchAddUsers(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	ctx := context.Background()
+	user := func(email string) *api.AddUserRequest {
+		return &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: email}
+	}
+
+	reply, err := s.BatchAddUsers(ctx, &api.BatchAddUsersRequest{
+		Requests: []*api.AddUserRequest{user("first@example.com"), user("second@example.com")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call batch add users: %v", err)
+	}
+	if len(reply.Results) != 2 || reply.Results[1].Index != 1 || reply.Results[1].User.Email != "second@example.com" {
+		t.Errorf("BatchAddUsers() results: %v", reply.Results)
+	}
+
+	// atomic batches are rolled back entirely.
+	_, err = s.BatchAddUsers(ctx, &api.BatchAddUsersRequest{
+		Requests: []*api.AddUserRequest{user("third@example.com"), user("first@example.com")},
+		Mode:     api.BatchMode_ATOMIC,
+	})
+	if status.Code(err) != codes.AlreadyExists || !strings.HasPrefix(status.Convert(err).Message(), "requests[1]: ") {
+		t.Fatalf("BatchAddUsers() with conflict: want AlreadyExists, got: %v", err)
+	}
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetMetadata()["index"] != "1" {
+		t.Errorf("BatchAddUsers() unexpected error info: %v", info)
+	}
+	_, err = s.BatchAddUsers(ctx, &api.BatchAddUsersRequest{
+		Requests: []*api.AddUserRequest{user("third@example.com"), user("fourth@example.com"), user("invalid")},
+	})
+	if fields := violatedFields(err); !reflect.DeepEqual(fields, []string{"requests[2].email"}) {
+		t.Errorf("BatchAddUsers() with invalid item: violated fields = %v (%v)", fields, err)
+	}
+	if count := countUsers(t, s); count != 2 {
+		t.Errorf("failed atomic batches stored users: %d", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 2 {
+		t.Errorf("unexpected add notifications count: %d", count)
+	}
+
+	// per item batches apply the valid items only.
+	reply, err = s.BatchAddUsers(ctx, &api.BatchAddUsersRequest{
+		Requests: []*api.AddUserRequest{
+			user("third@example.com"), user("invalid"), user("third@example.com"), user("fourth@example.com"),
+		},
+		Mode: api.BatchMode_PER_ITEM,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call batch add users: %v", err)
+	}
+	want := []codes.Code{codes.OK, codes.InvalidArgument, codes.AlreadyExists, codes.OK}
+	if got := resultCodes(reply.Results); !reflect.DeepEqual(got, want) {
+		t.Errorf("BatchAddUsers() result codes = %v, want %v", got, want)
+	}
+	if reason := reply.Results[2].Reason; reason != app.ReasonAlreadyExists {
+		t.Errorf("BatchAddUsers() result reason = %s", reason)
+	}
+	if count := countUsers(t, s); count != 4 {
+		t.Errorf("per item batch stored %d users, want 4", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 4 {
+		t.Errorf("unexpected add notifications count: %d", count)
+	}
+
+	requests := make([]*api.AddUserRequest, 101)
+	for i := range requests {
+		requests[i] = user("user_" + strconv.Itoa(i) + "@example.com")
+	}
+	if _, err = s.BatchAddUsers(ctx, &api.BatchAddUsersRequest{Requests: requests}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("BatchAddUsers() with too many items: want InvalidArgument, got: %v", err)
+	}
+}
+
+func TestUserStore_BatchUpdateAndDeleteUsers(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	ctx := context.Background()
+	first := makeUser(t, s, "first@example.com")
+	second := makeUser(t, s, "second@example.com")
+
+	country := "DE"
+	reply, err := s.BatchUpdateUsers(ctx, &api.BatchUpdateUsersRequest{
+		Requests: []*api.UpdateUserRequest{
+			{Id: first, Country: &country},
+			{Country: &country},
+			{Id: second, Country: &country, ExpectedVersion: 5},
+			{Id: second, User: &api.User{Country: "FR"}},
+		},
+		Mode: api.BatchMode_PER_ITEM,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call batch update users: %v", err)
+	}
+	want := []codes.Code{codes.OK, codes.InvalidArgument, codes.Aborted, codes.OK}
+	if got := resultCodes(reply.Results); !reflect.DeepEqual(got, want) {
+		t.Errorf("BatchUpdateUsers() result codes = %v, want %v", got, want)
+	}
+	if u := reply.Results[3].User; u.Country != "FR" || u.Version != 2 {
+		t.Errorf("BatchUpdateUsers() returned user: %v", u)
+	}
+	if count := notifier.ActionCallsCount("update"); count != 2 {
+		t.Errorf("unexpected update notifications count: %d", count)
+	}
+
+	_, err = s.BatchDeleteUsers(ctx, &api.BatchDeleteUsersRequest{
+		Requests: []*api.DeleteUserRequest{{Id: first}, {Id: "missing"}},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("BatchDeleteUsers() with missing user: want NotFound, got: %v", err)
+	}
+	if count := countUsers(t, s); count != 2 {
+		t.Errorf("failed atomic batch deleted users")
+	}
+
+	deleteReply, err := s.BatchDeleteUsers(ctx, &api.BatchDeleteUsersRequest{
+		Requests: []*api.DeleteUserRequest{{Id: first}, {Id: "missing"}, {Id: first}},
+		Mode:     api.BatchMode_PER_ITEM,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call batch delete users: %v", err)
+	}
+	want = []codes.Code{codes.OK, codes.NotFound, codes.NotFound}
+	if got := resultCodes(deleteReply.Results); !reflect.DeepEqual(got, want) {
+		t.Errorf("BatchDeleteUsers() result codes = %v, want %v", got, want)
+	}
+	if count := countUsers(t, s); count != 1 {
+		t.Errorf("per item batch left %d users, want 1", count)
+	}
+	if count := notifier.ActionCallsCount("delete"); count != 1 {
+		t.Errorf("unexpected delete notifications count: %d", count)
+	}
+}
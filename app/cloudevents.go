@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sir-hassan/grpc-service-user/webhook"
+)
+
+const (
+	// CloudEventsSource is the source attribute of the CloudEvents posted by HTTPNotifier.
+	CloudEventsSource = "/grpc-service-user"
+
+	cloudEventsSpecVersion       = "1.0"
+	cloudEventsStructuredContent = "application/cloudevents+json"
+	jsonContent                  = "application/json"
+)
+
+// PayloadFormat is the format of the requests posted to a webhook.
+type PayloadFormat int
+
+const (
+	// LegacyFormat posts the user JSON to '<webhook>/<action>'.
+	LegacyFormat PayloadFormat = iota
+	// CloudEventsStructuredFormat posts a CloudEvents 1.0 JSON event to the webhook, the user JSON being its data.
+	CloudEventsStructuredFormat
+	// CloudEventsBinaryFormat posts the user JSON to the webhook, with the CloudEvents 1.0 attributes in 'ce-*'
+	// headers.
+	CloudEventsBinaryFormat
+)
+
+// ParsePayloadFormat returns the format named s: legacy, cloudevents-structured or cloudevents-binary.
+func ParsePayloadFormat(s string) (PayloadFormat, error) {
+	switch s {
+	case "legacy":
+		return LegacyFormat, nil
+	case "cloudevents-structured":
+		return CloudEventsStructuredFormat, nil
+	case "cloudevents-binary":
+		return CloudEventsBinaryFormat, nil
+	default:
+		return 0, fmt.Errorf("unknown webhook payload format '%s'", s)
+	}
+}
+
+// notification is a user change notification, as posted to the webhooks. Its id and time are kept across the retries
+// and replays, so that receivers can deduplicate it.
+type notification struct {
+	id     string
+	action string
+	userID string
+	time   time.Time
+	// JSON of the PublicUser, as of the change.
+	data []byte
+}
+
+// cloudEvent is a CloudEvents 1.0 event in the JSON format of the structured mode.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// eventType returns the CloudEvents type of an action: user.created, user.updated...
+func eventType(action string) string {
+	switch action {
+	case "add":
+		return "user.created"
+	case "update":
+		return "user.updated"
+	case "delete":
+		return "user.deleted"
+	case "restore":
+		return "user.restored"
+	case "purge":
+		return "user.purged"
+	default:
+		return "user." + action
+	}
+}
+
+// encode returns the path appended to the webhook url, the body and the headers of the request posting notif in the
+// format f. The headers hold the webhook.IDHeader, webhook.TypeHeader and webhook.SubjectHeader attributes, signed
+// along with the body.
+func (f PayloadFormat) encode(notif *notification) (string, []byte, http.Header, error) {
+	header := http.Header{}
+	header.Set(webhook.IDHeader, notif.id)
+	header.Set(webhook.SubjectHeader, notif.userID)
+	eventTime := notif.time.UTC().Format(time.RFC3339Nano)
+
+	switch f {
+	case CloudEventsStructuredFormat:
+		body, err := json.Marshal(&cloudEvent{
+			SpecVersion:     cloudEventsSpecVersion,
+			ID:              notif.id,
+			Source:          CloudEventsSource,
+			Type:            eventType(notif.action),
+			Subject:         notif.userID,
+			Time:            eventTime,
+			DataContentType: jsonContent,
+			Data:            notif.data,
+		})
+		if err != nil {
+			return "", nil, nil, err
+		}
+		header.Set("Content-Type", cloudEventsStructuredContent)
+		header.Set(webhook.TypeHeader, eventType(notif.action))
+
+		return "", body, header, nil
+	case CloudEventsBinaryFormat:
+		header.Set("Content-Type", jsonContent)
+		header.Set(webhook.TypeHeader, eventType(notif.action))
+		header.Set("ce-specversion", cloudEventsSpecVersion)
+		header.Set("ce-id", notif.id)
+		header.Set("ce-source", CloudEventsSource)
+		header.Set("ce-type", eventType(notif.action))
+		header.Set("ce-subject", notif.userID)
+		header.Set("ce-time", eventTime)
+
+		return "", notif.data, header, nil
+	default:
+		header.Set("Content-Type", jsonContent)
+		header.Set(webhook.TypeHeader, notif.action)
+
+		return "/" + notif.action, notif.data, header, nil
+	}
+}
@@ -0,0 +1,294 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeadLetterPageSize = 50
+	maxDeadLetterPageSize     = 500
+	// maxDeadLetterReplay is the maximum number of dead letters replayed by a single ReplayDeadLetters call.
+	maxDeadLetterReplay = 100
+)
+
+// DeadLetter is a delivery of a notification to a single webhook that failed for good, kept until it's replayed or
+// discarded with DeadLetterAdmin.
+type DeadLetter struct {
+	ID      uint64 `gorm:"primaryKey"`
+	Webhook string `gorm:"index"`
+	Action  string
+	UserID  string `gorm:"index"`
+	Payload []byte
+	// The id and time of the notification, kept by the replays.
+	EventID   string
+	EventTime time.Time
+	// Set when the notification was sent through the outbox.
+	OutboxEventID *uint64
+
+	Attempts  int
+	LastError string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func newDeadLetter(webHook WebHook, notif *notification) *DeadLetter {
+	return &DeadLetter{
+		Webhook:   webHook.URL,
+		Action:    notif.action,
+		UserID:    notif.userID,
+		Payload:   notif.data,
+		EventID:   notif.id,
+		EventTime: notif.time,
+	}
+}
+
+// notification returns the notification of the dead letter, the dead letters recorded before they had an EventID
+// falling back to their id and creation time.
+func (l *DeadLetter) notification() *notification {
+	notif := &notification{id: l.EventID, action: l.Action, userID: l.UserID, time: l.EventTime, data: l.Payload}
+	if notif.id == "" {
+		notif.id, notif.time = "dead-letter-"+strconv.FormatUint(l.ID, 10), l.CreatedAt
+	}
+
+	return notif
+}
+
+// DeadLetterRecorder records the failed deliveries of HTTPNotifier.
+type DeadLetterRecorder interface {
+	RecordDeadLetter(ctx context.Context, letter *DeadLetter) error
+}
+
+// DeadLetterStore stores the dead letters in the database.
+type DeadLetterStore struct {
+	db *gorm.DB
+}
+
+func NewDeadLetterStore(db *gorm.DB) *DeadLetterStore {
+	return &DeadLetterStore{db: db}
+}
+
+func (s *DeadLetterStore) RecordDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	return s.db.WithContext(ctx).Create(letter).Error
+}
+
+var _ DeadLetterRecorder = &DeadLetterStore{}
+
+// DeadLetterAdmin implements api.DeadLetterAdminServer on top of a DeadLetterStore, dead letters being replayed with
+// the notifier that failed to deliver them.
+type DeadLetterAdmin struct {
+	api.DeadLetterAdminServer
+	lg       zerolog.Logger
+	store    *DeadLetterStore
+	notifier *HTTPNotifier
+}
+
+func NewDeadLetterAdmin(lg zerolog.Logger, store *DeadLetterStore, notifier *HTTPNotifier) *DeadLetterAdmin {
+	return &DeadLetterAdmin{
+		lg:       lg,
+		store:    store,
+		notifier: notifier,
+	}
+}
+
+var _ api.DeadLetterAdminServer = &DeadLetterAdmin{}
+
+func (a *DeadLetterAdmin) ListDeadLetters(
+	ctx context.Context, req *api.ListDeadLettersRequest,
+) (*api.ListDeadLettersReply, error) {
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize < 0 || pageSize > maxDeadLetterPageSize:
+		return nil, invalidArgumentError("page_size", fmt.Sprintf("'page_size' must be between 0 and %d",
+			maxDeadLetterPageSize))
+	case pageSize == 0:
+		pageSize = defaultDeadLetterPageSize
+	}
+
+	query := filterDeadLetters(a.store.db.WithContext(ctx), req.Filter)
+	if req.PageToken != "" {
+		// page tokens are the id of the last dead letter of the previous page.
+		afterID, err := strconv.ParseUint(req.PageToken, 10, 64)
+		if err != nil {
+			return nil, invalidArgumentError("page_token", "invalid 'page_token' field")
+		}
+		query = query.Where("id > ?", afterID)
+	}
+
+	var letters []DeadLetter
+	if err := query.Order("id").Limit(pageSize + 1).Find(&letters).Error; err != nil {
+		return nil, internalStatusError(ctx, a.lg, err, "select query in ListDeadLetters func")
+	}
+
+	reply := &api.ListDeadLettersReply{}
+	if len(letters) > pageSize {
+		letters = letters[:pageSize]
+		reply.NextPageToken = strconv.FormatUint(letters[pageSize-1].ID, 10)
+	}
+	for i := range letters {
+		reply.DeadLetters = append(reply.DeadLetters, toAPIDeadLetter(&letters[i]))
+	}
+
+	return reply, nil
+}
+
+func (a *DeadLetterAdmin) GetDeadLetter(
+	ctx context.Context, req *api.GetDeadLetterRequest,
+) (*api.GetDeadLetterReply, error) {
+	var letters []DeadLetter
+	if err := a.store.db.WithContext(ctx).Where("id = ?", req.Id).Limit(1).Find(&letters).Error; err != nil {
+		return nil, internalStatusError(ctx, a.lg, err, "select query in GetDeadLetter func")
+	}
+	if len(letters) == 0 {
+		return nil, deadLetterNotFoundError(req.Id)
+	}
+
+	return &api.GetDeadLetterReply{DeadLetter: toAPIDeadLetter(&letters[0])}, nil
+}
+
+func (a *DeadLetterAdmin) ReplayDeadLetters(
+	ctx context.Context, req *api.ReplayDeadLettersRequest,
+) (*api.ReplayDeadLettersReply, error) {
+	if len(req.Ids) > maxDeadLetterReplay {
+		return nil, invalidArgumentError("ids", fmt.Sprintf("at most %d dead letters are replayed at once",
+			maxDeadLetterReplay))
+	}
+	query, err := selectDeadLetters(a.store.db.WithContext(ctx), req.Ids, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []DeadLetter
+	if err = query.Order("id").Limit(maxDeadLetterReplay).Find(&letters).Error; err != nil {
+		return nil, internalStatusError(ctx, a.lg, err, "select query in ReplayDeadLetters func")
+	}
+
+	reply := &api.ReplayDeadLettersReply{}
+	found := map[uint64]bool{}
+	for i := range letters {
+		letter := &letters[i]
+		found[letter.ID] = true
+
+		replayErr := a.replay(ctx, letter)
+		if ctx.Err() != nil {
+			return nil, internalStatusError(ctx, a.lg, ctx.Err(), "replay in ReplayDeadLetters func")
+		}
+		if replayErr != nil {
+			reply.Failed++
+			reply.Failures = append(reply.Failures, &api.DeadLetterFailure{Id: letter.ID, Error: replayErr.Error()})
+
+			continue
+		}
+		reply.Replayed++
+	}
+	for _, id := range req.Ids {
+		if !found[id] {
+			reply.Failed++
+			reply.Failures = append(reply.Failures, &api.DeadLetterFailure{Id: id, Error: "dead letter not found"})
+		}
+	}
+
+	return reply, nil
+}
+
+// replay posts letter to its webhook in a single attempt, in the current format and with the current secrets of the
+// webhook. It's deleted once delivered, or its failure is recorded.
+func (a *DeadLetterAdmin) replay(ctx context.Context, letter *DeadLetter) error {
+	webHook := a.notifier.webHook(letter.Webhook)
+	_, _, deliveryErr := a.notifier.post(ctx, webHook, letter.notification(), letter.Attempts+1)
+
+	db := a.store.db.WithContext(ctx)
+	if deliveryErr == nil {
+		if err := db.Delete(letter).Error; err != nil {
+			return fmt.Errorf("delivered, but not deleted: %w", err)
+		}
+
+		return nil
+	}
+
+	err := db.Model(letter).Updates(map[string]any{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": deliveryErr.Error(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("%w, failure not recorded: %v", deliveryErr, err)
+	}
+
+	return deliveryErr
+}
+
+func (a *DeadLetterAdmin) DiscardDeadLetters(
+	ctx context.Context, req *api.DiscardDeadLettersRequest,
+) (*api.DiscardDeadLettersReply, error) {
+	query, err := selectDeadLetters(a.store.db.WithContext(ctx), req.Ids, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty filter selects all the dead letters, which gorm refuses to delete by default.
+	res := query.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&DeadLetter{})
+	if res.Error != nil {
+		return nil, internalStatusError(ctx, a.lg, res.Error, "delete query in DiscardDeadLetters func")
+	}
+
+	return &api.DiscardDeadLettersReply{Discarded: res.RowsAffected}, nil
+}
+
+// selectDeadLetters returns query restricted to the dead letters selected either by id, or with a filter.
+func selectDeadLetters(query *gorm.DB, ids []uint64, filter *api.DeadLetterFilter) (*gorm.DB, error) {
+	switch {
+	case len(ids) != 0 && filter != nil:
+		return nil, invalidArgumentError("ids", "'ids' and 'filter' fields can't be combined")
+	case len(ids) != 0:
+		return query.Where("id IN ?", ids), nil
+	case filter != nil:
+		return filterDeadLetters(query, filter), nil
+	default:
+		return nil, invalidArgumentError("ids", "missing 'ids' or 'filter' field, an empty filter selects all")
+	}
+}
+
+// filterDeadLetters returns query restricted to the dead letters matching filter, if any.
+func filterDeadLetters(query *gorm.DB, filter *api.DeadLetterFilter) *gorm.DB {
+	if filter.GetWebhook() != "" {
+		query = query.Where("webhook = ?", filter.Webhook)
+	}
+	if filter.GetAction() != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.GetUserId() != "" {
+		query = query.Where("user_id = ?", filter.UserId)
+	}
+
+	return query
+}
+
+func toAPIDeadLetter(letter *DeadLetter) *api.DeadLetter {
+	apiLetter := &api.DeadLetter{
+		Id:      letter.ID,
+		Webhook: letter.Webhook,
+		Action:  letter.Action,
+		UserId:  letter.UserID,
+		Payload: string(letter.Payload),
+		//nolint
+		Attempts:  int32(letter.Attempts),
+		LastError: letter.LastError,
+		CreatedAt: timestamppb.New(letter.CreatedAt),
+		UpdatedAt: timestamppb.New(letter.UpdatedAt),
+		EventId:   letter.EventID,
+		EventTime: timestamppb.New(letter.EventTime),
+	}
+	if letter.OutboxEventID != nil {
+		apiLetter.OutboxEventId = *letter.OutboxEventID
+	}
+
+	return apiLetter
+}
@@ -0,0 +1,164 @@
+package app_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPNotifier_DeadLetter(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/rejecting") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer svr.Close()
+
+	webHooks := []app.WebHook{{URL: svr.URL}, {URL: svr.URL + "/rejecting"}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(),
+		app.NewDeadLetterStore(db))
+	event := &app.OutboxEvent{ID: 7, Action: "add", UserID: "111", Payload: []byte(`{"ID":"111"}`)}
+
+	// the failed delivery is dead lettered, so the event isn't delivered again.
+	if err = notifier.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver() with a rejecting webhook: unexpected error: %v", err)
+	}
+	var letters []app.DeadLetter
+	if err = db.Find(&letters).Error; err != nil {
+		t.Fatalf("select dead letters: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("Deliver() recorded %d dead letters, want 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.Webhook != svr.URL+"/rejecting" || letter.Action != "add" || letter.UserID != "111" ||
+		string(letter.Payload) != `{"ID":"111"}` || letter.OutboxEventID == nil || *letter.OutboxEventID != 7 {
+		t.Errorf("unexpected dead letter: %v", letter)
+	}
+	// the events enqueued without an EventID fall back to their outbox id.
+	if letter.EventID != "outbox-7" {
+		t.Errorf("dead letter event id = %q, want outbox-7", letter.EventID)
+	}
+	if letter.Attempts != 1 || !strings.Contains(letter.LastError, "400") {
+		t.Errorf("unexpected dead letter failure: %d attempts, %q", letter.Attempts, letter.LastError)
+	}
+}
+
+func TestDeadLetterAdmin(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	var down atomic.Bool
+	down.Store(true)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer svr.Close()
+
+	store := app.NewDeadLetterStore(db)
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, nil, 10, app.DefaultRetryPolicy(), store)
+	admin := app.NewDeadLetterAdmin(zerolog.Logger{}, store, notifier)
+	ctx := context.Background()
+
+	var ids []uint64
+	for _, webhook := range []string{svr.URL, svr.URL, svr.URL + "/other"} {
+		letter := &app.DeadLetter{Webhook: webhook, Action: "add", UserID: "111", Payload: []byte(`{}`), Attempts: 5}
+		if err = store.RecordDeadLetter(ctx, letter); err != nil {
+			t.Fatalf("record dead letter: %v", err)
+		}
+		ids = append(ids, letter.ID)
+	}
+
+	filter := &api.DeadLetterFilter{Webhook: svr.URL}
+	page, err := admin.ListDeadLetters(ctx, &api.ListDeadLettersRequest{Filter: filter, PageSize: 1})
+	if err != nil || len(page.DeadLetters) != 1 || page.DeadLetters[0].Id != ids[0] || page.NextPageToken == "" {
+		t.Fatalf("ListDeadLetters() first page: %v, %v", page, err)
+	}
+	page, err = admin.ListDeadLetters(ctx,
+		&api.ListDeadLettersRequest{Filter: filter, PageSize: 1, PageToken: page.NextPageToken})
+	if err != nil || len(page.DeadLetters) != 1 || page.DeadLetters[0].Id != ids[1] || page.NextPageToken != "" {
+		t.Fatalf("ListDeadLetters() last page: %v, %v", page, err)
+	}
+
+	got, err := admin.GetDeadLetter(ctx, &api.GetDeadLetterRequest{Id: ids[2]})
+	if err != nil || got.DeadLetter.Webhook != svr.URL+"/other" || got.DeadLetter.Attempts != 5 {
+		t.Errorf("GetDeadLetter(): %v, %v", got, err)
+	}
+	if _, err = admin.GetDeadLetter(ctx, &api.GetDeadLetterRequest{Id: 999}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetDeadLetter() with unknown id: want NotFound, got: %v", err)
+	}
+
+	// failed replays are recorded, and the dead letters kept.
+	replay, err := admin.ReplayDeadLetters(ctx, &api.ReplayDeadLettersRequest{Ids: []uint64{ids[0], 999}})
+	if err != nil || replay.Replayed != 0 || replay.Failed != 2 {
+		t.Fatalf("ReplayDeadLetters() with the webhook down: %v, %v", replay, err)
+	}
+	got, err = admin.GetDeadLetter(ctx, &api.GetDeadLetterRequest{Id: ids[0]})
+	if err != nil || got.DeadLetter.Attempts != 6 || !strings.Contains(got.DeadLetter.LastError, "503") {
+		t.Errorf("GetDeadLetter() after failed replay: %v, %v", got, err)
+	}
+
+	down.Store(false)
+	replay, err = admin.ReplayDeadLetters(ctx, &api.ReplayDeadLettersRequest{Filter: filter})
+	if err != nil || replay.Replayed != 2 || replay.Failed != 0 {
+		t.Fatalf("ReplayDeadLetters() with filter: %v, %v", replay, err)
+	}
+	if _, err = admin.GetDeadLetter(ctx, &api.GetDeadLetterRequest{Id: ids[0]}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetDeadLetter() of replayed dead letter: want NotFound, got: %v", err)
+	}
+
+	_, err = admin.DiscardDeadLetters(ctx, &api.DiscardDeadLettersRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DiscardDeadLetters() without selection: want InvalidArgument, got: %v", err)
+	}
+	_, err = admin.DiscardDeadLetters(ctx, &api.DiscardDeadLettersRequest{Ids: ids, Filter: filter})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DiscardDeadLetters() with ids and filter: want InvalidArgument, got: %v", err)
+	}
+	discard, err := admin.DiscardDeadLetters(ctx, &api.DiscardDeadLettersRequest{Filter: &api.DeadLetterFilter{}})
+	if err != nil || discard.Discarded != 1 {
+		t.Errorf("DiscardDeadLetters() with empty filter: %v, %v", discard, err)
+	}
+}
+
+func TestHTTPNotifier_QueueFull(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	webHooks := []app.WebHook{{URL: "http://localhost:1/hook"}}
+	// the notifier isn't started, so its queue of a single notification fills up right away.
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 1, app.DefaultRetryPolicy(),
+		app.NewDeadLetterStore(db))
+
+	for i := 0; i < 3; i++ {
+		notifier.Notify(&app.User{ID: "111"}, app.AddNotification)
+	}
+
+	var letters []app.DeadLetter
+	if err = db.Find(&letters).Error; err != nil {
+		t.Fatalf("select dead letters: %v", err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("Notify() with a full queue recorded %d dead letters, want 2", len(letters))
+	}
+	if letters[0].Attempts != 0 || letters[0].LastError != "notification queue full" || letters[0].EventID == "" {
+		t.Errorf("unexpected dead letter: %v", letters[0])
+	}
+}
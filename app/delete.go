@@ -0,0 +1,247 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize is the maximum number of users purged in a single transaction by PurgeDeletedUsers.
+const purgeBatchSize = 100
+
+var (
+	errUserNotFound   = errors.New("user not found")
+	errUserNotDeleted = errors.New("user not deleted")
+)
+
+func (s *UserStore) RestoreUser(ctx context.Context, req *api.RestoreUserRequest) (*api.RestoreUserReply, error) {
+	reply := &api.RestoreUserReply{}
+	call, replayed, err := s.idempotentCall(ctx, "RestoreUser", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+	if req.Id == "" {
+		return nil, invalidArgumentError("id", "missing or empty 'id' field")
+	}
+
+	restoredUser := User{}
+	replayed, err = call.transaction(ctx, "restore transaction in RestoreUser func", reply, func(tx *gorm.DB) error {
+		if err := restoreUser(tx, req.Id, &restoredUser); err != nil {
+			return s.deleteStateError(ctx, err, req.Id, "restore query in RestoreUser func")
+		}
+
+		return s.enqueueNotifications(ctx, tx, RestoreNotification, &restoredUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !replayed {
+		s.notifyCommitted(RestoreNotification, &restoredUser)
+	}
+
+	return reply, nil
+}
+
+// restoreUser restores the deleted user having id within tx, and reads it into restoredUser.
+func restoreUser(tx *gorm.DB, id string, restoredUser *User) error {
+	// the condition on deleted_at makes concurrent restores and purges of the same user safe.
+	res := tx.Unscoped().Model(&User{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return deletedState(tx, id)
+	}
+
+	return tx.First(restoredUser, "id = ?", id).Error
+}
+
+func (s *UserStore) PurgeUser(ctx context.Context, req *api.PurgeUserRequest) (*api.PurgeUserReply, error) {
+	reply := &api.PurgeUserReply{}
+	call, replayed, err := s.idempotentCall(ctx, "PurgeUser", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+	if req.Id == "" {
+		return nil, invalidArgumentError("id", "missing or empty 'id' field")
+	}
+
+	purgedUser := User{}
+	replayed, err = call.transaction(ctx, "purge transaction in PurgeUser func", reply, func(tx *gorm.DB) error {
+		if err := purgeUser(tx, req.Id, &purgedUser); err != nil {
+			return s.deleteStateError(ctx, err, req.Id, "delete query in PurgeUser func")
+		}
+
+		return s.enqueueNotifications(ctx, tx, PurgeNotification, &purgedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !replayed {
+		s.notifyCommitted(PurgeNotification, &purgedUser)
+	}
+
+	return reply, nil
+}
+
+// purgeUser deletes for good the deleted user having id within tx, and reads it into purgedUser beforehand.
+func purgeUser(tx *gorm.DB, id string, purgedUser *User) error {
+	var users []User
+	if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Limit(1).Find(&users).Error; err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return deletedState(tx, id)
+	}
+	*purgedUser = users[0]
+
+	res := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&User{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// restored in the meantime.
+		return errUserNotDeleted
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers deletes for good up to limit users deleted before the given time, and returns how many were
+// purged. Users restored concurrently are left untouched, the whole batch is then rolled back and an error returned.
+func (s *UserStore) PurgeDeletedUsers(ctx context.Context, before time.Time, limit int) (int, error) {
+	var users []*User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("deleted_at < ?", before).Order("deleted_at").Limit(limit).Find(&users).Error
+		if err != nil || len(users) == 0 {
+			return err
+		}
+
+		ids := make([]string, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+		}
+		res := tx.Unscoped().Where("id IN ? AND deleted_at < ?", ids, before).Delete(&User{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != int64(len(users)) {
+			return fmt.Errorf("%d of %d users restored while being purged", int64(len(users))-res.RowsAffected, len(users))
+		}
+
+		return s.enqueueNotifications(ctx, tx, PurgeNotification, users...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.notifyCommitted(PurgeNotification, users...)
+
+	return len(users), nil
+}
+
+// deletedState returns why the user having id couldn't be restored or purged.
+func deletedState(tx *gorm.DB, id string) error {
+	var count int64
+	if err := tx.Unscoped().Model(&User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUserNotFound
+	}
+
+	return errUserNotDeleted
+}
+
+func (s *UserStore) deleteStateError(ctx context.Context, err error, id string, msg string) error {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return notFoundError("id", id)
+	case errors.Is(err, errUserNotDeleted):
+		return notDeletedError(id)
+	default:
+		return s.internalError(ctx, err, msg)
+	}
+}
+
+// Purger periodically purges the users deleted for longer than the retention period. Like HTTPNotifier, it runs in
+// a goroutine spawned by Start().
+type Purger struct {
+	lg        zerolog.Logger
+	store     *UserStore
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurger(lg zerolog.Logger, store *UserStore, retention time.Duration, interval time.Duration) *Purger {
+	return &Purger{
+		lg:        lg,
+		store:     store,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+func (p *Purger) Start(cancelChan chan any) chan any {
+	doneChan := make(chan any)
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+	loop:
+		for {
+			p.purge(cancelChan)
+
+			select {
+			case <-ticker.C:
+			case <-cancelChan:
+				break loop
+			}
+		}
+		p.lg.Info().Msg("purger stopped")
+		close(doneChan)
+	}()
+
+	p.lg.Info().Dur("retention", p.retention).Msg("purger started")
+
+	return doneChan
+}
+
+// purge purges batches of users until there are no more users to purge, or it gets canceled.
+func (p *Purger) purge(cancelChan chan any) {
+	before := time.Now().Add(-p.retention)
+	for {
+		count, err := p.store.PurgeDeletedUsers(context.Background(), before, purgeBatchSize)
+		if err != nil {
+			p.lg.Err(err).Msg("purging deleted users")
+
+			return
+		}
+		if count != 0 {
+			p.lg.Info().Int("count", count).Msg("purged deleted users")
+		}
+		if count < purgeBatchSize {
+			return
+		}
+
+		select {
+		case <-cancelChan:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package app_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserStore_SoftDelete(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	id := makeUser(t, s, "me@example.com")
+	makeUser(t, s, "other@example.com")
+	ctx := context.Background()
+
+	if _, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id}); err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+	if _, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteUser() of a deleted user: want NotFound, got: %v", err)
+	}
+	if _, err = s.GetUser(ctx, &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: id}}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetUser() of a deleted user: want NotFound, got: %v", err)
+	}
+
+	stream := &mockListUsersServer{}
+	if err = s.ListUsers(&api.ListUsersRequest{}, stream); err != nil || len(stream.users) != 1 {
+		t.Errorf("ListUsers() listed %d users, want 1 (%v)", len(stream.users), err)
+	}
+	stream = &mockListUsersServer{}
+	if err = s.ListUsers(&api.ListUsersRequest{IncludeDeleted: true}, stream); err != nil || len(stream.users) != 2 {
+		t.Fatalf("ListUsers() with deleted listed %d users, want 2 (%v)", len(stream.users), err)
+	}
+	for _, u := range stream.users {
+		if (u.Id == id) != (u.DeletedAt != nil) {
+			t.Errorf("ListUsers() user %s has deleted_at %v", u.Id, u.DeletedAt)
+		}
+	}
+
+	if _, err = s.RestoreUser(ctx, &api.RestoreUserRequest{Id: id}); err != nil {
+		t.Fatalf("unexpected error on call restore user: %v", err)
+	}
+	reply, err := s.GetUser(ctx, &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: id}})
+	if err != nil || reply.User.DeletedAt != nil {
+		t.Errorf("GetUser() of a restored user: %v, %v", reply, err)
+	}
+	if _, err = s.RestoreUser(ctx, &api.RestoreUserRequest{Id: id}); status.Code(err) != codes.FailedPrecondition ||
+		errorReason(err) != app.ReasonUserNotDeleted {
+		t.Errorf("RestoreUser() of a user not deleted: want FailedPrecondition, got: %v", err)
+	}
+	if _, err = s.RestoreUser(ctx, &api.RestoreUserRequest{Id: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("RestoreUser() of a missing user: want NotFound, got: %v", err)
+	}
+
+	if _, err = s.PurgeUser(ctx, &api.PurgeUserRequest{Id: id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PurgeUser() of a user not deleted: want FailedPrecondition, got: %v", err)
+	}
+	if _, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id}); err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+	if _, err = s.PurgeUser(ctx, &api.PurgeUserRequest{Id: id}); err != nil {
+		t.Fatalf("unexpected error on call purge user: %v", err)
+	}
+	if _, err = s.RestoreUser(ctx, &api.RestoreUserRequest{Id: id}); status.Code(err) != codes.NotFound {
+		t.Errorf("RestoreUser() of a purged user: want NotFound, got: %v", err)
+	}
+	var count int64
+	db.Unscoped().Model(&app.User{}).Where("id = ?", id).Count(&count)
+	if count != 0 {
+		t.Errorf("PurgeUser() left the user in the database")
+	}
+
+	for action, want := range map[string]int{"delete": 2, "restore": 1, "purge": 1} {
+		if got := notifier.ActionCallsCount(action); got != want {
+			t.Errorf("unexpected %s notifications count: %d, want %d", action, got, want)
+		}
+	}
+}
+
+func TestUserStore_PurgeDeletedUsers(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+
+	ids := []string{
+		makeUser(t, s, "first@example.com"),
+		makeUser(t, s, "second@example.com"),
+		makeUser(t, s, "third@example.com"),
+		makeUser(t, s, "fourth@example.com"),
+	}
+	for _, id := range ids[:3] {
+		if _, err = s.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: id}); err != nil {
+			t.Fatalf("unexpected error on call delete user: %v", err)
+		}
+	}
+	// the first two users were deleted long ago.
+	db.Unscoped().Model(&app.User{}).Where("id IN ?", ids[:2]).Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+	cancelChan := make(chan any)
+	doneChan := app.NewPurger(zerolog.Logger{}, s, 24*time.Hour, time.Hour).Start(cancelChan)
+	time.Sleep(100 * time.Millisecond)
+	close(cancelChan)
+	<-doneChan
+
+	var remaining []app.User
+	db.Unscoped().Order("email").Find(&remaining)
+	if len(remaining) != 2 || remaining[0].ID != ids[3] || remaining[1].ID != ids[2] {
+		t.Errorf("purger left users: %v", remaining)
+	}
+	if got := notifier.ActionCallsCount("purge"); got != 2 {
+		t.Errorf("unexpected purge notifications count: %d", got)
+	}
+
+	purged, err := s.PurgeDeletedUsers(context.Background(), time.Now(), 10)
+	if err != nil || purged != 1 {
+		t.Errorf("PurgeDeletedUsers() = %d, %v, want 1", purged, err)
+	}
+}
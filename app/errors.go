@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/rs/zerolog"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is the domain of the google.rpc.ErrorInfo details attached to errors.
+const errorDomain = "user.api"
+
+// Stable ErrorInfo reasons of the errors returned by the service, clients may rely on them.
+const (
+	// ReasonInvalidArgument is the reason of errors caused by malformed request fields, all listed in a BadRequest.
+	ReasonInvalidArgument = "INVALID_ARGUMENT"
+	// ReasonAlreadyExists is the reason of errors caused by a unique field already used by another user.
+	ReasonAlreadyExists = "ALREADY_EXISTS"
+	// ReasonUserNotFound is the reason of errors caused by a missing user.
+	ReasonUserNotFound = "USER_NOT_FOUND"
+	// ReasonVersionMismatch is the reason of errors caused by an 'expected_version' that isn't the current one.
+	ReasonVersionMismatch = "VERSION_MISMATCH"
+	// ReasonUserNotDeleted is the reason of errors caused by restoring or purging a user that isn't deleted.
+	ReasonUserNotDeleted = "USER_NOT_DELETED"
+	// ReasonIdempotencyKeyReused is the reason of errors caused by an idempotency key already used by another request.
+	ReasonIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	// ReasonAmbiguousNickname is the reason of errors caused by looking up a user by a nickname shared by several
+	// users, which happens when nicknames aren't unique.
+	ReasonAmbiguousNickname = "AMBIGUOUS_NICKNAME"
+	// ReasonDeadLetterNotFound is the reason of errors caused by a missing dead letter.
+	ReasonDeadLetterNotFound = "DEAD_LETTER_NOT_FOUND"
+	// ReasonUnavailable is the reason of transient errors, the request may be retried after the RetryInfo delay.
+	ReasonUnavailable = "UNAVAILABLE"
+	// ReasonInternal is the reason of unexpected errors, details are only logged server side.
+	ReasonInternal = "INTERNAL"
+)
+
+// unavailableRetryDelay is the delay suggested to clients retrying after a transient error.
+const unavailableRetryDelay = time.Second
+
+// ResourceInfo types of users and dead letters.
+const (
+	userResourceType       = "user"
+	deadLetterResourceType = "dead_letter"
+)
+
+// statusWithDetails returns a status error carrying details, falling back to a bare status error if the details
+// can't be attached.
+func statusWithDetails(code codes.Code, msg string, details ...protoiface.MessageV1) error {
+	st, err := status.New(code, msg).WithDetails(details...)
+	if err != nil {
+		return status.Error(code, msg)
+	}
+
+	return st.Err()
+}
+
+// uniqueViolationField returns the user field whose unique index err violates, if any.
+func uniqueViolationField(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if pgErr.Code != "23505" {
+			return "", false
+		}
+		field, ok := uniqueIndexFields[pgErr.ConstraintName]
+
+		return field, ok
+	}
+
+	// sqlite errors carry no structured constraint name, they name either the index of expression indexes or the
+	// column: "UNIQUE constraint failed: index 'idx_users_email'" or "UNIQUE constraint failed: users.nickname".
+	msg := err.Error()
+	if !strings.Contains(msg, "UNIQUE constraint failed") {
+		return "", false
+	}
+	for index, field := range uniqueIndexFields {
+		if strings.Contains(msg, "'"+index+"'") || strings.Contains(msg, "users."+field) {
+			return field, true
+		}
+	}
+
+	return "", false
+}
+
+// isUniqueViolation reports whether err is the violation of any unique index or primary key.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// alreadyExistsError returns the error of a unique user field already used by another user.
+func alreadyExistsError(field string) error {
+	return statusWithDetails(codes.AlreadyExists, "'"+field+"' field already used by another user",
+		&errdetails.ErrorInfo{Reason: ReasonAlreadyExists, Domain: errorDomain, Metadata: map[string]string{"field": field}},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: "already used by another user"},
+		}},
+	)
+}
+
+// invalidArgumentError returns the error of a malformed request field.
+func invalidArgumentError(field string, msg string) error {
+	return statusWithDetails(codes.InvalidArgument, msg,
+		&errdetails.ErrorInfo{Reason: ReasonInvalidArgument, Domain: errorDomain, Metadata: map[string]string{"field": field}},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: field, Description: msg},
+		}},
+	)
+}
+
+// fieldViolationsError returns the error of several malformed request fields, reported at once.
+func fieldViolationsError(violations []*errdetails.BadRequest_FieldViolation) error {
+	fields := make([]string, len(violations))
+	for i, violation := range violations {
+		fields[i] = violation.Field
+	}
+
+	return statusWithDetails(codes.InvalidArgument, "invalid fields: "+strings.Join(fields, ", "),
+		&errdetails.ErrorInfo{
+			Reason: ReasonInvalidArgument, Domain: errorDomain, Metadata: map[string]string{"fields": strings.Join(fields, ",")},
+		},
+		&errdetails.BadRequest{FieldViolations: violations},
+	)
+}
+
+// notFoundError returns the error of a missing user, looked up by one of its unique fields.
+func notFoundError(field string, value string) error {
+	return statusWithDetails(codes.NotFound, field+" not found",
+		&errdetails.ErrorInfo{Reason: ReasonUserNotFound, Domain: errorDomain, Metadata: map[string]string{"field": field}},
+		&errdetails.ResourceInfo{ResourceType: userResourceType, ResourceName: value, Description: field + " not found"},
+	)
+}
+
+// ambiguousNicknameError returns the error of a user looked up by a nickname shared by several users.
+func ambiguousNicknameError() error {
+	return statusWithDetails(codes.FailedPrecondition, "nickname shared by several users, look the user up by id or email",
+		&errdetails.ErrorInfo{
+			Reason: ReasonAmbiguousNickname, Domain: errorDomain, Metadata: map[string]string{"field": "nickname"},
+		},
+	)
+}
+
+// deadLetterNotFoundError returns the error of a missing dead letter.
+func deadLetterNotFoundError(id uint64) error {
+	return statusWithDetails(codes.NotFound, "dead letter not found",
+		&errdetails.ErrorInfo{Reason: ReasonDeadLetterNotFound, Domain: errorDomain},
+		&errdetails.ResourceInfo{
+			ResourceType: deadLetterResourceType, ResourceName: strconv.FormatUint(id, 10), Description: "id not found",
+		},
+	)
+}
+
+// versionMismatchError returns the error of a write expecting another version of the user than the current one.
+func versionMismatchError(expected int64, current int64) error {
+	return statusWithDetails(codes.Aborted, "'expected_version' doesn't match the current version of the user",
+		&errdetails.ErrorInfo{Reason: ReasonVersionMismatch, Domain: errorDomain, Metadata: map[string]string{
+			"expected_version": strconv.FormatInt(expected, 10),
+			"current_version":  strconv.FormatInt(current, 10),
+		}},
+	)
+}
+
+// notDeletedError returns the error of restoring or purging a user that isn't deleted.
+func notDeletedError(id string) error {
+	return statusWithDetails(codes.FailedPrecondition, "user isn't deleted",
+		&errdetails.ErrorInfo{Reason: ReasonUserNotDeleted, Domain: errorDomain},
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "DELETED", Subject: userResourceType + "/" + id, Description: "user isn't deleted"},
+		}},
+	)
+}
+
+// idempotencyKeyReusedError returns the error of an idempotency key already used by a request with another payload,
+// or by another rpc.
+func idempotencyKeyReusedError() error {
+	msg := "'idempotency_key' already used by another request"
+
+	return statusWithDetails(codes.InvalidArgument, msg,
+		&errdetails.ErrorInfo{
+			Reason: ReasonIdempotencyKeyReused, Domain: errorDomain, Metadata: map[string]string{"field": "idempotency_key"},
+		},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "idempotency_key", Description: msg},
+		}},
+	)
+}
+
+// internalError logs an unexpected error along with the request id, and maps it to the error returned to the client:
+// cancellations and deadlines are reported as such, transient database errors as Unavailable with a retry delay,
+// and anything else as an opaque Internal error.
+func (s *UserStore) internalError(ctx context.Context, err error, msg string) error {
+	return internalStatusError(ctx, s.lg, err, msg)
+}
+
+// internalStatusError is UserStore.internalError for the services logging with lg.
+func internalStatusError(ctx context.Context, lg zerolog.Logger, err error, msg string) error {
+	lg.Err(err).Str("request_id", RequestID(ctx)).Msg(msg)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "request canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "request deadline exceeded")
+	case isTransient(err):
+		return statusWithDetails(codes.Unavailable, "service temporarily unavailable",
+			&errdetails.ErrorInfo{Reason: ReasonUnavailable, Domain: errorDomain},
+			&errdetails.RetryInfo{RetryDelay: durationpb.New(unavailableRetryDelay)},
+		)
+	default:
+		return statusWithDetails(codes.Internal, "internal server error",
+			&errdetails.ErrorInfo{Reason: ReasonInternal, Domain: errorDomain},
+		)
+	}
+}
+
+// isTransient reports whether err is a database error worth retrying: connection failures, timeouts, serialization
+// failures and deadlocks, or the server shutting down or running out of resources.
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), // connection exception
+			strings.HasPrefix(pgErr.Code, "53"), // insufficient resources
+			pgErr.Code == "40001",               // serialization failure
+			pgErr.Code == "40P01",               // deadlock detected
+			pgErr.Code == "57P01":               // admin shutdown
+			return true
+		}
+	}
+
+	return false
+}
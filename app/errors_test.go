@@ -0,0 +1,150 @@
+package app_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func errorDetail[T any](err error) (T, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if detail, ok := d.(T); ok {
+			return detail, true
+		}
+	}
+
+	var zero T
+
+	return zero, false
+}
+
+func TestUserStore_ErrorDetails(t *testing.T) {
+	s := makeStoreWithUsers(t, 1)
+
+	_, err := s.GetUser(context.Background(), &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: "missing"}})
+	if status.Code(err) != codes.NotFound || errorReason(err) != app.ReasonUserNotFound {
+		t.Errorf("GetUser() unexpected error: %v", err)
+	}
+	if resource, ok := errorDetail[*errdetails.ResourceInfo](err); !ok || resource.ResourceType != "user" ||
+		resource.ResourceName != "missing" {
+		t.Errorf("GetUser() unexpected resource info: %v", resource)
+	}
+
+	_, err = s.DeleteUser(context.Background(), &api.DeleteUserRequest{})
+	if status.Code(err) != codes.InvalidArgument || errorReason(err) != app.ReasonInvalidArgument {
+		t.Errorf("DeleteUser() unexpected error: %v", err)
+	}
+	if fields := violatedFields(err); len(fields) != 1 || fields[0] != "id" {
+		t.Errorf("DeleteUser() violated fields = %v, want [id]", fields)
+	}
+
+	err = s.ListUsers(&api.ListUsersRequest{OrderBy: []*api.OrderBy{{Field: "email"}, {Field: "password"}}},
+		&mockListUsersServer{})
+	if fields := violatedFields(err); len(fields) != 1 || fields[0] != "order_by[1]" {
+		t.Errorf("ListUsers() violated fields = %v, want [order_by[1]]", fields)
+	}
+}
+
+func TestUserStore_InternalErrors(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	req := &api.GetUserRequest{Selector: &api.GetUserRequest_Email{Email: "me@example.com"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = s.GetUser(ctx, req); status.Code(err) != codes.Canceled {
+		t.Errorf("GetUser() with canceled context: want Canceled, got: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	_, err = s.GetUser(context.Background(), req)
+	if status.Code(err) != codes.Internal || errorReason(err) != app.ReasonInternal {
+		t.Errorf("GetUser() with closed database: want Internal, got: %v", err)
+	}
+	if msg := status.Convert(err).Message(); msg != "internal server error" {
+		t.Errorf("GetUser() leaked error message: %s", msg)
+	}
+}
+
+func TestRequestIDInterceptors(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(app.UnaryRequestIDInterceptor),
+		grpc.ChainStreamInterceptor(app.StreamRequestIDInterceptor),
+	)
+	api.RegisterUserStoreServer(server, makeStoreWithUsers(t, 1))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := api.NewUserStoreClient(conn)
+
+	t.Run("propagated", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), app.RequestIDHeader, "my-request")
+		var header metadata.MD
+		_, err := client.GetUser(ctx, &api.GetUserRequest{}, grpc.Header(&header))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("GetUser() unexpected error: %v", err)
+		}
+		if got := header.Get(app.RequestIDHeader); len(got) != 1 || got[0] != "my-request" {
+			t.Errorf("GetUser() request id header = %v, want [my-request]", got)
+		}
+		if info, ok := errorDetail[*errdetails.RequestInfo](err); !ok || info.RequestId != "my-request" {
+			t.Errorf("GetUser() unexpected request info: %v", info)
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		tooLong := strings.Repeat("x", 200)
+		ctx := metadata.AppendToOutgoingContext(context.Background(), app.RequestIDHeader, tooLong)
+		var header metadata.MD
+		_, err := client.GetUser(ctx, &api.GetUserRequest{}, grpc.Header(&header))
+		info, _ := errorDetail[*errdetails.RequestInfo](err)
+		got := header.Get(app.RequestIDHeader)
+		if len(got) != 1 || got[0] == "" || got[0] == tooLong || info.GetRequestId() != got[0] {
+			t.Errorf("GetUser() request id header = %v, request info = %v", got, info)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), app.RequestIDHeader, "my-stream")
+		stream, err := client.ListUsers(ctx, &api.ListUsersRequest{})
+		if err != nil {
+			t.Fatalf("ListUsers() unexpected error: %v", err)
+		}
+		header, err := stream.Header()
+		if err != nil {
+			t.Fatalf("ListUsers() unexpected header error: %v", err)
+		}
+		if got := header.Get(app.RequestIDHeader); len(got) != 1 || got[0] != "my-stream" {
+			t.Errorf("ListUsers() request id header = %v, want [my-stream]", got)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+)
+
+// updatableUserFields maps the api.User fields that UpdateUser can update, as update_mask paths, to the
+// corresponding optional field of the request.
+var updatableUserFields = map[string]func(req *api.UpdateUserRequest) **string{
+	"first_name": func(req *api.UpdateUserRequest) **string { return &req.FirstName },
+	"last_name":  func(req *api.UpdateUserRequest) **string { return &req.LastName },
+	"nickname":   func(req *api.UpdateUserRequest) **string { return &req.Nickname },
+	"email":      func(req *api.UpdateUserRequest) **string { return &req.Email },
+	"country":    func(req *api.UpdateUserRequest) **string { return &req.Country },
+}
+
+// readOnlyUserFields are the api.User fields that are managed by the service.
+var readOnlyUserFields = map[string]bool{
+	"id": true, "created_at": true, "updated_at": true, "deleted_at": true, "version": true,
+}
+
+// userFieldValues returns the updatable fields values of u, by update_mask path.
+func userFieldValues(u *api.User) map[string]string {
+	return map[string]string{
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"nickname":   u.Nickname,
+		"email":      u.Email,
+		"country":    u.Country,
+	}
+}
+
+// applyUpdateMask translates the 'user' and 'update_mask' fields of req into its optional fields, so that both ways
+// of updating a user share the same code path.
+func applyUpdateMask(req *api.UpdateUserRequest) error {
+	if req.User == nil {
+		if req.UpdateMask != nil {
+			return invalidArgumentError("user", "'update_mask' field given without 'user' field")
+		}
+
+		return nil
+	}
+
+	for _, path := range validUpdateMaskPaths() {
+		if *updatableUserFields[path](req) != nil {
+			return invalidArgumentError("user", fmt.Sprintf("'user' field can't be combined with '%s' field", path))
+		}
+	}
+	if req.User.Id != "" {
+		if req.Id != "" && req.Id != req.User.Id {
+			return invalidArgumentError("id", "'id' and 'user.id' fields don't match")
+		}
+		req.Id = req.User.Id
+	}
+
+	values := userFieldValues(req.User)
+	var paths []string
+	switch {
+	case req.UpdateMask == nil:
+		// the implied mask is made of the fields set in 'user'.
+		for path, value := range values {
+			if value != "" {
+				paths = append(paths, path)
+			}
+		}
+	case len(req.UpdateMask.Paths) == 1 && req.UpdateMask.Paths[0] == "*":
+		for path := range values {
+			paths = append(paths, path)
+		}
+	default:
+		paths = req.UpdateMask.Paths
+	}
+
+	for _, path := range paths {
+		field, ok := updatableUserFields[path]
+		if !ok {
+			if readOnlyUserFields[path] {
+				return invalidArgumentError("update_mask", fmt.Sprintf("field %q can't be updated", path))
+			}
+
+			return invalidArgumentError("update_mask", fmt.Sprintf(
+				"unknown field %q, valid fields are: %s", path, strings.Join(validUpdateMaskPaths(), ", "),
+			))
+		}
+		value := values[path]
+		*field(req) = &value
+	}
+
+	return nil
+}
+
+func validUpdateMaskPaths() []string {
+	paths := make([]string, 0, len(updatableUserFields))
+	for path := range updatableUserFields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
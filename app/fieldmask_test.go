@@ -0,0 +1,132 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestUserStore_UpdateUser_UpdateMask(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	ctx := context.Background()
+
+	added, err := s.AddUser(ctx, &api.AddUserRequest{
+		FirstName: "fn", LastName: "ln", Nickname: "nick", Email: "me@example.com", Country: "DE",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+	get := func() *api.User {
+		reply, err := s.GetUser(ctx, &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: added.Id}})
+		if err != nil {
+			t.Fatalf("unexpected error on call get user: %v", err)
+		}
+
+		return reply.User
+	}
+
+	// masked fields are updated or cleared, the others are left untouched.
+	_, err = s.UpdateUser(ctx, &api.UpdateUserRequest{
+		Id:         added.Id,
+		User:       &api.User{FirstName: "ignored", Country: "fr"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "country"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	if u := get(); u.FirstName != "fn" || u.Nickname != "" || u.Country != "FR" {
+		t.Errorf("UpdateUser() with mask stored: %v", u)
+	}
+
+	// without mask, the fields set in 'user' are updated, and the id can be taken from 'user'.
+	_, err = s.UpdateUser(ctx, &api.UpdateUserRequest{User: &api.User{Id: added.Id, LastName: "new"}})
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	if u := get(); u.LastName != "new" || u.FirstName != "fn" || u.Email != "me@example.com" {
+		t.Errorf("UpdateUser() with implied mask stored: %v", u)
+	}
+
+	nickname := "nick"
+	tests := []struct {
+		name      string
+		req       *api.UpdateUserRequest
+		wantError string
+	}{
+		{
+			name: "full replacement missing required fields",
+			req: &api.UpdateUserRequest{
+				Id:         added.Id,
+				User:       &api.User{FirstName: "fn"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"*"}},
+			},
+			wantError: "invalid fields: last_name, email",
+		},
+		{
+			name: "unknown path",
+			req: &api.UpdateUserRequest{
+				Id:         added.Id,
+				User:       &api.User{},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+			},
+			wantError: "unknown field \"password\"",
+		},
+		{
+			name: "read only path",
+			req: &api.UpdateUserRequest{
+				Id:         added.Id,
+				User:       &api.User{Version: 10},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"version"}},
+			},
+			wantError: "field \"version\" can't be updated",
+		},
+		{
+			name: "combined with optional fields",
+			req: &api.UpdateUserRequest{
+				Id:       added.Id,
+				User:     &api.User{FirstName: "fn"},
+				Nickname: &nickname,
+			},
+			wantError: "can't be combined with 'nickname' field",
+		},
+		{
+			name:      "mask without user",
+			req:       &api.UpdateUserRequest{Id: added.Id, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}}},
+			wantError: "without 'user' field",
+		},
+		{
+			name:      "empty mask",
+			req:       &api.UpdateUserRequest{Id: added.Id, User: &api.User{}, UpdateMask: &fieldmaskpb.FieldMask{}},
+			wantError: "no field to update",
+		},
+		{
+			name:      "no field set",
+			req:       &api.UpdateUserRequest{Id: added.Id},
+			wantError: "no field to update",
+		},
+		{
+			name:      "mismatching ids",
+			req:       &api.UpdateUserRequest{Id: added.Id, User: &api.User{Id: "other", FirstName: "fn"}},
+			wantError: "don't match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.UpdateUser(ctx, tt.req)
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+			}
+		})
+	}
+}
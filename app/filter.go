@@ -0,0 +1,151 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"gorm.io/gorm/clause"
+)
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	timeField
+)
+
+type filterField struct {
+	column string
+	kind   fieldKind
+}
+
+// userFilterFields maps the api field names ListUsers can be filtered by to their database columns. It is the only
+// source of column names for filters, so untrusted filter fields never reach the query.
+var userFilterFields = map[string]filterField{
+	"id":         {column: "id", kind: stringField},
+	"first_name": {column: "first_name", kind: stringField},
+	"last_name":  {column: "last_name", kind: stringField},
+	"nickname":   {column: "nickname", kind: stringField},
+	"email":      {column: "email", kind: stringField},
+	"country":    {column: "country", kind: stringField},
+	"created_at": {column: "created_at", kind: timeField},
+	"updated_at": {column: "updated_at", kind: timeField},
+}
+
+// validFilterFields returns the sorted list of accepted filter fields of the given kind.
+func validFilterFields(kind fieldKind) []string {
+	var keys []string
+	for key, field := range userFilterFields {
+		if field.kind == kind {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// filterConditions translates the ListUsers filters map and the structured filters into where conditions. It returns
+// an InvalidArgument status error if any filter is invalid.
+func filterConditions(filters map[string]string, where []*api.FieldFilter) ([]clause.Expression, error) {
+	keys := make([]string, 0, len(filters))
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	// sorting makes the generated query deterministic.
+	sort.Strings(keys)
+
+	conds := make([]clause.Expression, 0, len(keys)+len(where))
+	for _, key := range keys {
+		field, ok := userFilterFields[key]
+		if !ok || field.kind != stringField {
+			return nil, invalidArgumentError("filters", fmt.Sprintf(
+				"invalid filter key %q, valid keys are: %s", key, strings.Join(validFilterFields(stringField), ", "),
+			))
+		}
+		conds = append(conds, clause.Eq{Column: clause.Column{Name: field.column}, Value: filters[key]})
+	}
+
+	for i, f := range where {
+		cond, err := fieldFilterCondition(f)
+		if err != nil {
+			return nil, invalidArgumentError(fmt.Sprintf("where[%d]", i), fmt.Sprintf("invalid filter 'where[%d]': %s", i, err))
+		}
+		conds = append(conds, cond)
+	}
+
+	return conds, nil
+}
+
+func fieldFilterCondition(f *api.FieldFilter) (clause.Expression, error) {
+	field, ok := userFilterFields[f.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q, valid fields are: %s", f.Field,
+			strings.Join(append(validFilterFields(stringField), validFilterFields(timeField)...), ", "))
+	}
+	column := clause.Column{Name: field.column}
+
+	if field.kind == timeField {
+		if f.Time == nil {
+			return nil, fmt.Errorf("missing 'time' operand for field %q", f.Field)
+		}
+		value := f.Time.AsTime()
+
+		switch f.Operator {
+		case api.FieldFilter_EQUAL:
+			return clause.Eq{Column: column, Value: value}, nil
+		case api.FieldFilter_GREATER_THAN:
+			return clause.Gt{Column: column, Value: value}, nil
+		case api.FieldFilter_GREATER_THAN_OR_EQUAL:
+			return clause.Gte{Column: column, Value: value}, nil
+		case api.FieldFilter_LESS_THAN:
+			return clause.Lt{Column: column, Value: value}, nil
+		case api.FieldFilter_LESS_THAN_OR_EQUAL:
+			return clause.Lte{Column: column, Value: value}, nil
+		default:
+			return nil, fmt.Errorf("operator %s is not supported on time field %q", f.Operator, f.Field)
+		}
+	}
+
+	if f.Operator == api.FieldFilter_IN {
+		if len(f.Values) == 0 {
+			return nil, fmt.Errorf("operator IN needs at least one value")
+		}
+		values := make([]any, len(f.Values))
+		for i, v := range f.Values {
+			values[i] = v
+		}
+
+		return clause.IN{Column: column, Values: values}, nil
+	}
+
+	if len(f.Values) != 1 {
+		return nil, fmt.Errorf("operator %s needs exactly one value", f.Operator)
+	}
+	value := f.Values[0]
+
+	switch f.Operator {
+	case api.FieldFilter_EQUAL:
+		return clause.Eq{Column: column, Value: value}, nil
+	case api.FieldFilter_PREFIX:
+		return likeCondition(column, escapeLike(value)+"%"), nil
+	case api.FieldFilter_CONTAINS:
+		return likeCondition(column, "%"+escapeLike(value)+"%"), nil
+	default:
+		return nil, fmt.Errorf("operator %s is not supported on string field %q", f.Operator, f.Field)
+	}
+}
+
+// likeCondition matches column against pattern case-insensitively, in a way that works on both postgres and sqlite.
+func likeCondition(column clause.Column, pattern string) clause.Expression {
+	return clause.Expr{SQL: "LOWER(?) LIKE ? ESCAPE '\\'", Vars: []any{column, strings.ToLower(pattern)}}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the LIKE wildcards in s, so it's matched literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
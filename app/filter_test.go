@@ -0,0 +1,263 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func makeStoreWithUsers(t testing.TB, count int) *app.UserStore {
+	t.Helper()
+
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	for i := 0; i < count; i++ {
+		_, err := s.AddUser(context.Background(), &api.AddUserRequest{
+			FirstName: "user_first_name_" + strconv.Itoa(i),
+			LastName:  "user_last_name_" + strconv.Itoa(i),
+			Nickname:  "nick_" + strconv.Itoa(i),
+			Email:     "some_" + strconv.Itoa(i) + "@example.com",
+			Country:   "DE",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error on call add user: %v", err)
+		}
+	}
+
+	return s
+}
+
+func TestUserStore_ListUsers_Filters(t *testing.T) {
+	s := makeStoreWithUsers(t, 5)
+
+	tests := []struct {
+		name      string
+		filters   map[string]string
+		wantCount int
+		wantError string
+	}{
+		{
+			name:      "no filters",
+			wantCount: 5,
+		},
+		{
+			name:      "single filter",
+			filters:   map[string]string{"email": "some_1@example.com"},
+			wantCount: 1,
+		},
+		{
+			name:      "multiple filters",
+			filters:   map[string]string{"country": "DE", "nickname": "nick_2"},
+			wantCount: 1,
+		},
+		{
+			name:      "no match",
+			filters:   map[string]string{"country": "FR"},
+			wantCount: 0,
+		},
+		{
+			name:      "unknown key",
+			filters:   map[string]string{"password": "x"},
+			wantError: "valid keys are: country, email, first_name, id, last_name, nickname",
+		},
+		{
+			name:      "injected key",
+			filters:   map[string]string{"1 = 1 OR email": "x"},
+			wantError: "code = InvalidArgument",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &mockListUsersServer{}
+			err := s.ListUsers(&api.ListUsersRequest{Filters: tt.filters}, stream)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error on call list users: %v", err)
+			}
+			if len(stream.users) != tt.wantCount {
+				t.Errorf("ListUsers() sent %d users, want %d", len(stream.users), tt.wantCount)
+			}
+		})
+	}
+}
+
+// FuzzUserStore_ListUsers_Filters checks that untrusted filter keys and values can't alter the query: every call
+// either succeeds with a whitelisted key, or gets rejected before hitting the database, and a matching value never
+// returns more than the single user it matches.
+func FuzzUserStore_ListUsers_Filters(f *testing.F) {
+	f.Add("email", "some_1@example.com")
+	f.Add("email = email OR email", "x")
+	f.Add("1=1; DROP TABLE users; --", "x")
+	f.Add("email", "x' OR '1'='1")
+	f.Add("country) OR (1=1", "DE")
+	f.Add("\"email\"", "some_1@example.com")
+	f.Add("EMAIL", "some_1@example.com")
+
+	s := makeStoreWithUsers(f, 3)
+	valid := map[string]bool{
+		"id": true, "first_name": true, "last_name": true, "nickname": true, "email": true, "country": true,
+	}
+
+	f.Fuzz(func(t *testing.T, key string, value string) {
+		stream := &mockListUsersServer{}
+		err := s.ListUsers(&api.ListUsersRequest{Filters: map[string]string{key: value}}, stream)
+
+		if !valid[key] {
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("ListUsers() with key %q: want InvalidArgument, got: %v", key, err)
+			}
+
+			return
+		}
+		if err != nil {
+			t.Fatalf("ListUsers() with key %q: unexpected error: %v", key, err)
+		}
+		// the only column sharing a value between users is country.
+		if key != "country" && len(stream.users) > 1 {
+			t.Fatalf("ListUsers() with key %q and value %q matched %d users", key, value, len(stream.users))
+		}
+
+		all := &mockListUsersServer{}
+		if err := s.ListUsers(&api.ListUsersRequest{}, all); err != nil || len(all.users) != 3 {
+			t.Fatalf("users table altered after filtering with key %q and value %q", key, value)
+		}
+	})
+}
+
+func TestUserStore_ListUsers_Where(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []app.User{
+		{ID: "1", FirstName: "Anna", Email: "anna@Example.com", Country: "DE", CreatedAt: base},
+		{ID: "2", FirstName: "Annabelle", Email: "belle@example.org", Country: "FR", CreatedAt: base.Add(time.Hour)},
+		{ID: "3", FirstName: "Bob", Email: "bob@example.com", Country: "IT", CreatedAt: base.Add(2 * time.Hour)},
+		{ID: "4", FirstName: "an_na", Email: "100%@example.com", Country: "DE", CreatedAt: base.Add(3 * time.Hour)},
+	}
+	for i := range users {
+		db.Create(&users[i])
+	}
+
+	tests := []struct {
+		name      string
+		where     []*api.FieldFilter
+		filters   map[string]string
+		wantIDs   []string
+		wantError string
+	}{
+		{
+			name:    "prefix",
+			where:   []*api.FieldFilter{{Field: "first_name", Operator: api.FieldFilter_PREFIX, Values: []string{"ann"}}},
+			wantIDs: []string{"1", "2"},
+		},
+		{
+			name:    "prefix with wildcard",
+			where:   []*api.FieldFilter{{Field: "first_name", Operator: api.FieldFilter_PREFIX, Values: []string{"an_"}}},
+			wantIDs: []string{"4"},
+		},
+		{
+			name:    "contains case insensitive",
+			where:   []*api.FieldFilter{{Field: "email", Operator: api.FieldFilter_CONTAINS, Values: []string{"EXAMPLE.COM"}}},
+			wantIDs: []string{"1", "3", "4"},
+		},
+		{
+			name:    "contains with wildcard",
+			where:   []*api.FieldFilter{{Field: "email", Operator: api.FieldFilter_CONTAINS, Values: []string{"%"}}},
+			wantIDs: []string{"4"},
+		},
+		{
+			name:    "in",
+			where:   []*api.FieldFilter{{Field: "country", Operator: api.FieldFilter_IN, Values: []string{"FR", "IT"}}},
+			wantIDs: []string{"2", "3"},
+		},
+		{
+			name: "time range",
+			where: []*api.FieldFilter{
+				{Field: "created_at", Operator: api.FieldFilter_GREATER_THAN_OR_EQUAL, Time: timestamppb.New(base.Add(time.Hour))},
+				{Field: "created_at", Operator: api.FieldFilter_LESS_THAN, Time: timestamppb.New(base.Add(3 * time.Hour))},
+			},
+			wantIDs: []string{"2", "3"},
+		},
+		{
+			name:    "combined with filters map",
+			where:   []*api.FieldFilter{{Field: "first_name", Operator: api.FieldFilter_PREFIX, Values: []string{"an"}}},
+			filters: map[string]string{"country": "DE"},
+			wantIDs: []string{"1", "4"},
+		},
+		{
+			name:      "unknown field",
+			where:     []*api.FieldFilter{{Field: "password", Operator: api.FieldFilter_EQUAL, Values: []string{"x"}}},
+			wantError: "unknown field \"password\"",
+		},
+		{
+			name:      "range on string field",
+			where:     []*api.FieldFilter{{Field: "email", Operator: api.FieldFilter_GREATER_THAN, Values: []string{"x"}}},
+			wantError: "not supported on string field",
+		},
+		{
+			name:      "prefix on time field",
+			where:     []*api.FieldFilter{{Field: "updated_at", Operator: api.FieldFilter_PREFIX, Time: timestamppb.Now()}},
+			wantError: "not supported on time field",
+		},
+		{
+			name:      "missing time operand",
+			where:     []*api.FieldFilter{{Field: "updated_at", Operator: api.FieldFilter_LESS_THAN}},
+			wantError: "missing 'time' operand",
+		},
+		{
+			name:      "empty in",
+			where:     []*api.FieldFilter{{Field: "country", Operator: api.FieldFilter_IN}},
+			wantError: "needs at least one value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &mockListUsersServer{}
+			err := s.ListUsers(&api.ListUsersRequest{Where: tt.where, Filters: tt.filters}, stream)
+			if tt.wantError != "" {
+				if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error on call list users: %v", err)
+			}
+
+			var ids []string
+			for _, u := range stream.users {
+				ids = append(ids, u.Id)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ListUsers() ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gorm.io/gorm"
+)
+
+const (
+	// IdempotencyKeyHeader is the request metadata key carrying the idempotency key of mutating calls, as an
+	// alternative to their 'idempotency_key' request field.
+	IdempotencyKeyHeader = "idempotency-key"
+
+	// DefaultIdempotencyKeyTTL is how long the replies of calls made with an idempotency key are replayed by default.
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 128
+)
+
+// errIdempotencyKeyTaken rolls back the transaction of a call whose idempotency key got stored by a concurrent call.
+var errIdempotencyKeyTaken = errors.New("idempotency key taken")
+
+// IdempotencyRecord is the stored reply of a call made with an idempotency key, replayed to the retries of the call
+// until it expires.
+type IdempotencyRecord struct {
+	IdempotencyKey string `gorm:"primaryKey"`
+	Method         string
+	// sha256 of the request, without its idempotency key, and with the HMAC of its passwords in place of them.
+	RequestHash []byte
+	Reply       []byte
+
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// WithIdempotencyKeyTTL sets how long the replies of calls made with an idempotency key are replayed,
+// DefaultIdempotencyKeyTTL is used otherwise.
+func WithIdempotencyKeyTTL(ttl time.Duration) UserStoreOption {
+	return func(s *UserStore) {
+		s.idempotencyKeyTTL = ttl
+	}
+}
+
+// WithIdempotencySecret sets the key of the HMAC fingerprinting the passwords of the requests made with an
+// idempotency key. All the instances of the service must share the same secret for retries with passwords to be
+// replayed across them and across restarts, a random one is generated otherwise.
+func WithIdempotencySecret(secret []byte) UserStoreOption {
+	return func(s *UserStore) {
+		s.idempotencySecret = secret
+	}
+}
+
+// idempotentRequest is a request of a mutating rpc, all of them have an 'idempotency_key' field.
+type idempotentRequest interface {
+	proto.Message
+	GetIdempotencyKey() string
+}
+
+// idempotentCall is a call of a mutating rpc. Calls made without an idempotency key are never replayed.
+type idempotentCall struct {
+	s           *UserStore
+	method      string
+	key         string
+	requestHash []byte
+}
+
+// idempotentCall returns the call of method made with req, along with whether reply got filled with the stored reply
+// of a former call made with the same key. It must be called before req gets altered, by applyUpdateMask for instance.
+func (s *UserStore) idempotentCall(
+	ctx context.Context, method string, req idempotentRequest, reply proto.Message,
+) (*idempotentCall, bool, error) {
+	call := &idempotentCall{s: s, method: method, key: req.GetIdempotencyKey()}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(IdempotencyKeyHeader); len(values) != 0 {
+		if call.key != "" && call.key != values[0] {
+			return nil, false, invalidArgumentError("idempotency_key",
+				"'idempotency_key' field doesn't match the '"+IdempotencyKeyHeader+"' metadata")
+		}
+		call.key = values[0]
+	}
+	if call.key == "" {
+		return call, false, nil
+	}
+	if !validMetadataValue(call.key, maxIdempotencyKeyLength) {
+		return nil, false, invalidArgumentError("idempotency_key", fmt.Sprintf(
+			"'idempotency_key' must be made of up to %d printable ascii characters", maxIdempotencyKeyLength))
+	}
+
+	// the key isn't hashed, so that the hash is the same whether the key is sent in the request or in the metadata.
+	// The plaintext passwords are replaced by their HMAC under a server side secret, as a bare sha256 stored for the
+	// whole TTL would be easy to brute force.
+	keyless := proto.Clone(req)
+	m := keyless.ProtoReflect()
+	m.Clear(m.Descriptor().Fields().ByName("idempotency_key"))
+	s.fingerprintPasswords(m)
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(keyless)
+	if err != nil {
+		return nil, false, s.internalError(ctx, err, "marshal request in idempotentCall func")
+	}
+	hash := sha256.Sum256(payload)
+	call.requestHash = hash[:]
+
+	replayed, err := call.replay(ctx, reply)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return call, replayed, nil
+}
+
+// fingerprintPasswords replaces the password fields of m, and of the messages nested in it like the items of batches,
+// by their HMAC. Calls retried with another password then don't match the stored request hash.
+func (s *UserStore) fingerprintPasswords(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Name() == "password" && fd.Kind() == protoreflect.StringKind:
+			mac := hmac.New(sha256.New, s.idempotencySecret)
+			mac.Write([]byte(v.String()))
+			m.Set(fd, protoreflect.ValueOfString(hex.EncodeToString(mac.Sum(nil))))
+		case fd.Message() == nil || fd.IsMap():
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				s.fingerprintPasswords(v.List().Get(i).Message())
+			}
+		default:
+			s.fingerprintPasswords(v.Message())
+		}
+
+		return true
+	})
+}
+
+// replay fills reply with the stored reply of the call made with the same key, and reports whether there was one.
+func (c *idempotentCall) replay(ctx context.Context, reply proto.Message) (bool, error) {
+	var records []IdempotencyRecord
+	err := c.s.db.WithContext(ctx).Where("idempotency_key = ? AND expires_at > ?", c.key, time.Now()).
+		Limit(1).Find(&records).Error
+	if err != nil {
+		return false, c.s.internalError(ctx, err, "select query in replay func")
+	}
+	if len(records) == 0 {
+		return false, nil
+	}
+	if records[0].Method != c.method || !bytes.Equal(records[0].RequestHash, c.requestHash) {
+		return false, idempotencyKeyReusedError()
+	}
+	if err = proto.Unmarshal(records[0].Reply, reply); err != nil {
+		return false, c.s.internalError(ctx, err, "unmarshal reply in replay func")
+	}
+
+	return true, nil
+}
+
+// transaction runs fn like UserStore.transaction, and stores the reply filled by fn under the key of the call in the
+// same transaction. The key is stored first, so that concurrent calls made with the same key wait for each other: fn
+// is only applied by the first one, whose reply is replayed to the others. It reports whether reply was replayed.
+func (c *idempotentCall) transaction(
+	ctx context.Context, msg string, reply proto.Message, fn func(tx *gorm.DB) error,
+) (bool, error) {
+	if c.key == "" {
+		return false, c.s.transaction(ctx, msg, fn)
+	}
+
+	err := c.s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// expired records are only purged periodically, the one of the key is dropped to reuse it.
+		err := tx.Where("idempotency_key = ? AND expires_at <= ?", c.key, now).Delete(&IdempotencyRecord{}).Error
+		if err != nil {
+			return err
+		}
+		record := &IdempotencyRecord{
+			IdempotencyKey: c.key,
+			Method:         c.method,
+			RequestHash:    c.requestHash,
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(c.s.idempotencyKeyTTL),
+		}
+		if err = tx.Create(record).Error; err != nil {
+			if isUniqueViolation(err) {
+				return errIdempotencyKeyTaken
+			}
+
+			return err
+		}
+
+		if err = fn(tx); err != nil {
+			return err
+		}
+
+		payload, err := proto.Marshal(reply)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(record).Update("reply", payload).Error
+	})
+	if errors.Is(err, errIdempotencyKeyTaken) {
+		replayed, err := c.replay(ctx, reply)
+		if err == nil && !replayed {
+			// the record expired right away.
+			return false, status.Error(codes.Aborted, "concurrent call with the same idempotency key, retry")
+		}
+
+		return replayed, err
+	}
+	if _, ok := status.FromError(err); !ok {
+		return false, c.s.internalError(ctx, err, msg)
+	}
+
+	return false, err
+}
+
+// PurgeExpiredIdempotencyRecords deletes the idempotency records expired before the given time, and returns how many
+// were deleted.
+func (s *UserStore) PurgeExpiredIdempotencyRecords(ctx context.Context, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).Where("expires_at <= ?", before).Delete(&IdempotencyRecord{})
+
+	return res.RowsAffected, res.Error
+}
+
+// IdempotencyRecordPurger periodically deletes the expired idempotency records. Like HTTPNotifier, it runs in a
+// goroutine spawned by Start().
+type IdempotencyRecordPurger struct {
+	lg       zerolog.Logger
+	store    *UserStore
+	interval time.Duration
+}
+
+func NewIdempotencyRecordPurger(lg zerolog.Logger, store *UserStore, interval time.Duration) *IdempotencyRecordPurger {
+	return &IdempotencyRecordPurger{
+		lg:       lg,
+		store:    store,
+		interval: interval,
+	}
+}
+
+func (p *IdempotencyRecordPurger) Start(cancelChan chan any) chan any {
+	doneChan := make(chan any)
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+	loop:
+		for {
+			count, err := p.store.PurgeExpiredIdempotencyRecords(context.Background(), time.Now())
+			if err != nil {
+				p.lg.Err(err).Msg("purging expired idempotency records")
+			} else if count != 0 {
+				p.lg.Info().Int64("count", count).Msg("purged expired idempotency records")
+			}
+
+			select {
+			case <-ticker.C:
+			case <-cancelChan:
+				break loop
+			}
+		}
+		p.lg.Info().Msg("idempotency record purger stopped")
+		close(doneChan)
+	}()
+
+	p.lg.Info().Dur("interval", p.interval).Msg("idempotency record purger started")
+
+	return doneChan
+}
+
+// itemIdempotencyKeyError returns the error of a batch or import item having its own idempotency key.
+func itemIdempotencyKeyError() error {
+	return invalidArgumentError("idempotency_key", "'idempotency_key' can only be set on the whole call, not on its items")
+}
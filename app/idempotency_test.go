@@ -0,0 +1,191 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUserStore_IdempotencyKey(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	ctx := context.Background()
+	req := &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "first@example.com", IdempotencyKey: "add-1"}
+
+	first, err := s.AddUser(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+	retried, err := s.AddUser(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error on retried call add user: %v", err)
+	}
+	if !proto.Equal(first, retried) {
+		t.Errorf("AddUser() retry replied %v, want %v", retried, first)
+	}
+
+	// the key is the same when sent in the metadata.
+	mdCtx := metadata.NewIncomingContext(ctx, metadata.Pairs(app.IdempotencyKeyHeader, "add-1"))
+	retried, err = s.AddUser(mdCtx, &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "first@example.com"})
+	if err != nil || retried.Id != first.Id {
+		t.Errorf("AddUser() retry with metadata key: %v, %v", retried, err)
+	}
+	// passwords are part of the stored request hash, so a retry with another password is a different request.
+	_, err = s.AddUser(ctx, &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "first@example.com",
+		Password: "other-password", IdempotencyKey: "add-1"})
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonIdempotencyKeyReused {
+		t.Errorf("AddUser() retry with another password: want IDEMPOTENCY_KEY_REUSED, got: %v", err)
+	}
+	withPassword := &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "second@example.com",
+		Password: "password", IdempotencyKey: "add-password"}
+	added, err := s.AddUser(ctx, withPassword)
+	if err != nil {
+		t.Fatalf("unexpected error on call add user with password: %v", err)
+	}
+	if retried, err = s.AddUser(ctx, withPassword); err != nil || retried.Id != added.Id {
+		t.Errorf("AddUser() retry with the same password: %v, %v", retried, err)
+	}
+	if count := countUsers(t, s); count != 2 {
+		t.Errorf("AddUser() retries stored %d users, want 2", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 2 {
+		t.Errorf("AddUser() retries notified %d times, want 2", count)
+	}
+
+	_, err = s.AddUser(ctx, &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "other@example.com",
+		IdempotencyKey: "add-1"})
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonIdempotencyKeyReused {
+		t.Errorf("AddUser() with reused key: want IDEMPOTENCY_KEY_REUSED, got: %v", err)
+	}
+	_, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: first.Id, IdempotencyKey: "add-1"})
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonIdempotencyKeyReused {
+		t.Errorf("DeleteUser() with key of AddUser: want IDEMPOTENCY_KEY_REUSED, got: %v", err)
+	}
+	_, err = s.AddUser(mdCtx, &api.AddUserRequest{FirstName: "fn", LastName: "ln", IdempotencyKey: "add-2"})
+	if fields := violatedFields(err); !reflect.DeepEqual(fields, []string{"idempotency_key"}) {
+		t.Errorf("AddUser() with mismatching keys: violated fields = %v (%v)", fields, err)
+	}
+
+	// failed calls aren't stored, so they can be retried.
+	country := "XX"
+	update := &api.UpdateUserRequest{Id: first.Id, Country: &country, IdempotencyKey: "update-1"}
+	if _, err = s.UpdateUser(ctx, update); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("UpdateUser() with invalid country: want InvalidArgument, got: %v", err)
+	}
+	country = "FR"
+	updated, err := s.UpdateUser(ctx, update)
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	if updated, err = s.UpdateUser(ctx, update); err != nil || updated.User.Version != 2 {
+		t.Errorf("UpdateUser() retry: %v, %v", updated, err)
+	}
+}
+
+func TestUserStore_IdempotencyKey_Batch(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	ctx := context.Background()
+	req := &api.BatchAddUsersRequest{
+		Requests: []*api.AddUserRequest{
+			{FirstName: "fn", LastName: "ln", Email: "first@example.com"},
+			{FirstName: "fn", LastName: "ln", Email: "invalid"},
+		},
+		Mode:           api.BatchMode_PER_ITEM,
+		IdempotencyKey: "batch-1",
+	}
+
+	first, err := s.BatchAddUsers(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error on call batch add users: %v", err)
+	}
+	retried, err := s.BatchAddUsers(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error on retried call batch add users: %v", err)
+	}
+	if !proto.Equal(first, retried) || len(retried.Results) != 2 {
+		t.Errorf("BatchAddUsers() retry replied %v, want %v", retried, first)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 1 {
+		t.Errorf("BatchAddUsers() retries notified %d times, want 1", count)
+	}
+	// the passwords of the items are part of the stored request hash too.
+	req.Requests[0].Password = "other-password"
+	_, err = s.BatchAddUsers(ctx, req)
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonIdempotencyKeyReused {
+		t.Errorf("BatchAddUsers() retry with another item password: want IDEMPOTENCY_KEY_REUSED, got: %v", err)
+	}
+
+	_, err = s.BatchDeleteUsers(ctx, &api.BatchDeleteUsersRequest{
+		Requests: []*api.DeleteUserRequest{{Id: first.Results[0].User.Id, IdempotencyKey: "item-1"}},
+	})
+	if fields := violatedFields(err); !reflect.DeepEqual(fields, []string{"requests[0].idempotency_key"}) {
+		t.Errorf("BatchDeleteUsers() with item key: violated fields = %v (%v)", fields, err)
+	}
+}
+
+func TestUserStore_IdempotencyKey_Expired(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithIdempotencyKeyTTL(-time.Second))
+	ctx := context.Background()
+	id := makeUser(t, s, "first@example.com")
+
+	req := &api.DeleteUserRequest{Id: id, IdempotencyKey: "delete-1"}
+	if _, err = s.DeleteUser(ctx, req); err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+	// the key expired right away, so the retry is applied again.
+	if _, err = s.DeleteUser(ctx, req); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteUser() retry with expired key: want NotFound, got: %v", err)
+	}
+
+	count, err := s.PurgeExpiredIdempotencyRecords(ctx, time.Now())
+	if err != nil || count != 1 {
+		t.Errorf("PurgeExpiredIdempotencyRecords() = %d, %v, want 1", count, err)
+	}
+}
+
+func TestIdempotencyRecordPurger(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithIdempotencyKeyTTL(-time.Second))
+	id := makeUser(t, s, "first@example.com")
+	_, err = s.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: id, IdempotencyKey: "delete-1"})
+	if err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+
+	cancelChan := make(chan any)
+	doneChan := app.NewIdempotencyRecordPurger(zerolog.Logger{}, s, time.Hour).Start(cancelChan)
+	time.Sleep(100 * time.Millisecond)
+	close(cancelChan)
+	<-doneChan
+
+	var count int64
+	if err = db.Model(&app.IdempotencyRecord{}).Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("purger left %d idempotency records: %v", count, err)
+	}
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	importChunkSize   = 500
+	maxImportFailures = 1000
+
+	// ImportDryRunHeader and ImportSuppressNotificationsHeader are the ImportUsers request metadata keys of its options.
+	ImportDryRunHeader                = "import-dry-run"
+	ImportSuppressNotificationsHeader = "import-suppress-notifications"
+
+	importChunkSavepoint = "import_chunk"
+)
+
+// errImportDryRun rolls back the chunk transactions of dry-run imports.
+var errImportDryRun = errors.New("import dry-run")
+
+type importOptions struct {
+	dryRun                bool
+	suppressNotifications bool
+}
+
+// importOptionsFromContext reads the ImportUsers options from the request metadata.
+func importOptionsFromContext(ctx context.Context) (importOptions, error) {
+	opts := importOptions{}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, opt := range map[string]*bool{
+		ImportDryRunHeader:                &opts.dryRun,
+		ImportSuppressNotificationsHeader: &opts.suppressNotifications,
+	} {
+		values := md.Get(key)
+		if len(values) == 0 {
+			continue
+		}
+		value, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return opts, invalidArgumentError(key, fmt.Sprintf("invalid '%s' metadata, want true or false", key))
+		}
+		*opt = value
+	}
+
+	return opts, nil
+}
+
+// importChunk holds the users waiting to be inserted, along with their index in the request stream.
+type importChunk struct {
+	users   []*User
+	indexes []int64
+}
+
+func (s *UserStore) ImportUsers(stream api.UserStore_ImportUsersServer) error {
+	ctx := stream.Context()
+	opts, err := importOptionsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	summary := &api.ImportSummary{DryRun: opts.dryRun}
+	chunk := &importChunk{}
+	for index := int64(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		summary.Received++
+		if req.IdempotencyKey != "" {
+			addImportFailure(summary, index, itemIdempotencyKeyError())
+
+			continue
+		}
+
+		newUser, err := s.newUser(ctx, req)
+		if err != nil {
+			if isInternal(err) {
+				return err
+			}
+			addImportFailure(summary, index, err)
+
+			continue
+		}
+		chunk.users = append(chunk.users, newUser)
+		chunk.indexes = append(chunk.indexes, index)
+
+		if len(chunk.users) == importChunkSize {
+			if err = s.insertImportChunk(ctx, chunk, opts, summary); err != nil {
+				return err
+			}
+			chunk = &importChunk{}
+		}
+	}
+	if len(chunk.users) != 0 {
+		if err = s.insertImportChunk(ctx, chunk, opts, summary); err != nil {
+			return err
+		}
+	}
+
+	return stream.SendAndClose(summary)
+}
+
+// insertImportChunk inserts the users of chunk in a single transaction, and records the outcome in summary. The whole chunk
+// is first inserted at once, and only when that fails, user after user so that the failing users can be skipped.
+func (s *UserStore) insertImportChunk(
+	ctx context.Context, chunk *importChunk, opts importOptions, summary *api.ImportSummary,
+) error {
+	var users []*User
+	var itemErrs []error
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.SavePoint(importChunkSavepoint).Error; err != nil {
+			return s.internalError(ctx, err, "savepoint query in ImportUsers func")
+		}
+		if err := tx.Create(&chunk.users).Error; err == nil {
+			users, itemErrs = chunk.users, make([]error, len(chunk.users))
+		} else {
+			if err := tx.RollbackTo(importChunkSavepoint).Error; err != nil {
+				return s.internalError(ctx, err, "rollback to savepoint query in ImportUsers func")
+			}
+
+			applies := make([]batchApplyFunc, len(chunk.users))
+			for i := range chunk.users {
+				newUser := chunk.users[i]
+				applies[i] = func(tx *gorm.DB) (*User, error) {
+					return newUser, s.insertUser(ctx, tx, newUser)
+				}
+			}
+			users, itemErrs, _, err = s.applyBatchItems(ctx, tx, applies, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		if opts.dryRun {
+			return errImportDryRun
+		}
+		if opts.suppressNotifications {
+			return nil
+		}
+
+		return s.enqueueNotifications(ctx, tx, AddNotification, appliedUsers(users)...)
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		if _, ok := status.FromError(err); !ok {
+			return s.internalError(ctx, err, "import transaction in ImportUsers func")
+		}
+
+		return err
+	}
+
+	for i, user := range users {
+		if user == nil {
+			addImportFailure(summary, chunk.indexes[i], itemErrs[i])
+
+			continue
+		}
+		summary.Imported++
+	}
+	if !opts.dryRun && !opts.suppressNotifications {
+		s.notifyCommitted(AddNotification, appliedUsers(users)...)
+	}
+
+	return nil
+}
+
+func addImportFailure(summary *api.ImportSummary, index int64, err error) {
+	summary.Failed++
+	if len(summary.Failures) == maxImportFailures {
+		summary.FailuresTruncated = true
+
+		return
+	}
+
+	st := status.Convert(err)
+	failure := &api.ImportFailure{Index: index, Code: int32(st.Code()), Message: st.Message()}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			failure.Reason = info.Reason
+		}
+	}
+	summary.Failures = append(summary.Failures, failure)
+}
@@ -0,0 +1,147 @@
+package app_test
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mockImportUsersServer struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []*api.AddUserRequest
+	summary  *api.ImportSummary
+}
+
+func (m *mockImportUsersServer) Recv() (*api.AddUserRequest, error) {
+	if len(m.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := m.requests[0]
+	m.requests = m.requests[1:]
+
+	return req, nil
+}
+
+func (m *mockImportUsersServer) SendAndClose(summary *api.ImportSummary) error {
+	m.summary = summary
+
+	return nil
+}
+
+func (m *mockImportUsersServer) Context() context.Context {
+	return m.ctx
+}
+
+func importRequests(count int) []*api.AddUserRequest {
+	requests := make([]*api.AddUserRequest, count)
+	for i := range requests {
+		requests[i] = &api.AddUserRequest{
+			FirstName: "fn",
+			LastName:  "ln",
+			Email:     "import_" + strconv.Itoa(i) + "@example.com",
+		}
+	}
+
+	return requests
+}
+
+func failureIndexes(summary *api.ImportSummary) []int64 {
+	var got []int64
+	for _, f := range summary.Failures {
+		got = append(got, f.Index)
+	}
+
+	return got
+}
+
+func TestUserStore_ImportUsers(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+
+	// the second chunk holds a duplicate email, and is thus inserted user after user.
+	requests := importRequests(600)
+	requests[3].Email = "invalid"
+	requests[550].Email = requests[10].Email
+	stream := &mockImportUsersServer{ctx: context.Background(), requests: requests}
+	if err = s.ImportUsers(stream); err != nil {
+		t.Fatalf("unexpected error on call import users: %v", err)
+	}
+	summary := stream.summary
+	if summary.Received != 600 || summary.Imported != 598 || summary.Failed != 2 || summary.DryRun {
+		t.Errorf("ImportUsers() summary: received %d, imported %d, failed %d",
+			summary.Received, summary.Imported, summary.Failed)
+	}
+	if got := failureIndexes(summary); !reflect.DeepEqual(got, []int64{3, 550}) {
+		t.Errorf("ImportUsers() failure indexes = %v, want [3 550]", got)
+	}
+	if f := summary.Failures[1]; codes.Code(f.Code) != codes.AlreadyExists || f.Reason != app.ReasonAlreadyExists {
+		t.Errorf("ImportUsers() unexpected failure: %v", f)
+	}
+	if count := countUsers(t, s); count != 598 {
+		t.Errorf("ImportUsers() stored %d users, want 598", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 598 {
+		t.Errorf("unexpected add notifications count: %d", count)
+	}
+}
+
+func TestUserStore_ImportUsers_Options(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{})
+	makeUser(t, s, "import_1@example.com")
+	notifier.Reset()
+
+	// dry runs report the outcome without storing anything.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(app.ImportDryRunHeader, "true"))
+	stream := &mockImportUsersServer{ctx: ctx, requests: importRequests(3)}
+	if err = s.ImportUsers(stream); err != nil {
+		t.Fatalf("unexpected error on call import users: %v", err)
+	}
+	if summary := stream.summary; !summary.DryRun || summary.Imported != 2 || summary.Failed != 1 {
+		t.Errorf("ImportUsers() dry-run summary: %v", summary)
+	}
+	if count := countUsers(t, s); count != 1 {
+		t.Errorf("ImportUsers() dry-run stored users: %d", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 0 {
+		t.Errorf("ImportUsers() dry-run notified: %d", count)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(app.ImportSuppressNotificationsHeader, "true"))
+	stream = &mockImportUsersServer{ctx: ctx, requests: importRequests(3)}
+	if err = s.ImportUsers(stream); err != nil {
+		t.Fatalf("unexpected error on call import users: %v", err)
+	}
+	if count := countUsers(t, s); count != 3 {
+		t.Errorf("ImportUsers() stored %d users, want 3", count)
+	}
+	if count := notifier.ActionCallsCount("add"); count != 0 {
+		t.Errorf("ImportUsers() with suppressed notifications notified: %d", count)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(app.ImportDryRunHeader, "maybe"))
+	err = s.ImportUsers(&mockImportUsersServer{ctx: ctx})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ImportUsers() with invalid dry-run metadata: want InvalidArgument, got: %v", err)
+	}
+}
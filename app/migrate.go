@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Unique indexes of the users table. Emails are unique case-insensitively, and empty values, meaning that the field
+// isn't set, never conflict.
+const (
+	emailIndex    = "idx_users_email"
+	nicknameIndex = "idx_users_nickname"
+)
+
+// uniqueIndexFields maps the unique indexes to the user field they constrain.
+var uniqueIndexFields = map[string]string{
+	emailIndex:    "email",
+	nicknameIndex: "nickname",
+}
+
+// Migrate creates or updates the database schema. Nicknames are only unique if uniqueNickname is set. Creating a
+// unique index fails if the stored users already violate it, in which case the duplicates must be fixed manually.
+func Migrate(db *gorm.DB, uniqueNickname bool) error {
+	if err := db.AutoMigrate(&User{}, &IdempotencyRecord{}, &OutboxEvent{}, &DeadLetter{}); err != nil {
+		return err
+	}
+
+	// Expression and partial indexes can't be declared with gorm tags, the syntax below works on both postgres and
+	// sqlite.
+	err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + emailIndex + " ON users (LOWER(email)) WHERE email <> ''").Error
+	if err != nil {
+		return fmt.Errorf("creating unique email index: %w", err)
+	}
+
+	if uniqueNickname {
+		err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + nicknameIndex + " ON users (nickname) WHERE nickname <> ''").Error
+	} else {
+		err = db.Exec("DROP INDEX IF EXISTS " + nicknameIndex).Error
+	}
+	if err != nil {
+		return fmt.Errorf("updating unique nickname index: %w", err)
+	}
+
+	return nil
+}
@@ -15,8 +15,28 @@ const (
 	AddNotification NotificationType = iota
 	DeleteNotification
 	UpdateNotification
+	RestoreNotification
+	PurgeNotification
 )
 
+// String returns the name of the notification type, as used in the webhook urls, or "" if it's unknown.
+func (t NotificationType) String() string {
+	switch t {
+	case AddNotification:
+		return "add"
+	case DeleteNotification:
+		return "delete"
+	case UpdateNotification:
+		return "update"
+	case RestoreNotification:
+		return "restore"
+	case PurgeNotification:
+		return "purge"
+	default:
+		return ""
+	}
+}
+
 type Notifier interface {
 	Notify(user *User, typ NotificationType)
 }
@@ -44,6 +64,10 @@ func (n *MockedNotifier) Notify(user *User, typ NotificationType) {
 		n.actionsList = append(n.actionsList, "delete")
 	case AddNotification:
 		n.actionsList = append(n.actionsList, "add")
+	case RestoreNotification:
+		n.actionsList = append(n.actionsList, "restore")
+	case PurgeNotification:
+		n.actionsList = append(n.actionsList, "purge")
 	default:
 		panic(fmt.Sprintf("logic error, unexpected typ: %v in Notify()\n", typ))
 	}
@@ -2,52 +2,98 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/webhook"
 )
 
-type queueMessage struct {
-	webHook string
-	user    *User
-	typ     NotificationType
+// WebHook is a webhook notified by HTTPNotifier, with post requests to '<URL>/<action>' in the legacy format, or to URL
+// with CloudEvents.
+type WebHook struct {
+	URL    string
+	Format PayloadFormat
+	// Secrets signing the requests in the webhook.SignatureHeader, requests being signed with all of them so that
+	// receivers can rotate their secret. Requests aren't signed without secrets.
+	Secrets [][]byte
 }
 
+// errQueueFull is the error of the notifications dropped because the queue of their webhook is full.
+var errQueueFull = errors.New("notification queue full")
+
 // HTTPNotifier implements Notifier in an asynchronous manner. HTTPNotifier appends notifications to be sent in a
-// channel and a goroutine (spawned by Start()) consumes that channel and fires the http requests. This is a very simple
-// FIFO queueing solutions.
+// channel per webhook and a goroutine per webhook (spawned by Start()) consumes that channel and fires the http
+// requests. This is a very simple FIFO queueing solutions. Failed requests are retried according to the retry policy,
+// delaying the following notifications of the same webhook meanwhile. Notify never blocks: the notifications of a
+// webhook whose queue is full are dead lettered, or dropped without dead letter recorder.
 type HTTPNotifier struct {
-	lg     zerolog.Logger
-	client *http.Client
+	lg          zerolog.Logger
+	client      *http.Client
+	retryPolicy RetryPolicy
+	// Records the deliveries failing for good, if not nil.
+	deadLetters DeadLetterRecorder
 
-	webHooks []string
+	webHooks []WebHook
 
-	// Very simple fifo queue to implement an asynchronous Notifier.
-	queue chan queueMessage
+	// Very simple fifo queues to implement an asynchronous Notifier, one per webhook.
+	queues []chan *notification
 }
 
-func NewHTTPNotifier(lg zerolog.Logger, httpClient *http.Client, webHooks []string, queueSize int) *HTTPNotifier {
+func NewHTTPNotifier(
+	lg zerolog.Logger, httpClient *http.Client, webHooks []WebHook, queueSize int, retryPolicy RetryPolicy,
+	deadLetters DeadLetterRecorder,
+) *HTTPNotifier {
+	queues := make([]chan *notification, len(webHooks))
+	for i := range queues {
+		queues[i] = make(chan *notification, queueSize)
+	}
+
 	return &HTTPNotifier{
-		lg:       lg,
-		client:   httpClient,
-		webHooks: webHooks,
-		queue:    make(chan queueMessage, queueSize),
+		lg:          lg,
+		client:      httpClient,
+		retryPolicy: retryPolicy,
+		deadLetters: deadLetters,
+		webHooks:    webHooks,
+		queues:      queues,
 	}
 }
 
 func (n *HTTPNotifier) Start(cancelChan chan any) chan any {
 	doneChan := make(chan any)
+
+	// canceling ctx interrupts the request or retry backoff in progress.
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-	loop:
-		for {
-			select {
-			case msg := <-n.queue:
-				n.notify(msg.webHook, msg.user, msg.typ)
-			case <-cancelChan:
-				break loop
+		<-cancelChan
+		cancel()
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := range n.webHooks {
+		wg.Add(1)
+		go func(webHook WebHook, queue chan *notification) {
+			defer wg.Done()
+			for {
+				select {
+				case notif := <-queue:
+					n.notify(ctx, webHook, notif)
+				case <-ctx.Done():
+					return
+				}
 			}
-		}
+		}(n.webHooks[i], n.queues[i])
+	}
+	go func() {
+		wg.Wait()
 		n.lg.Info().Msg("http notifier stopped")
 		close(doneChan)
 	}()
@@ -57,50 +103,211 @@ func (n *HTTPNotifier) Start(cancelChan chan any) chan any {
 	return doneChan
 }
 
-func (n *HTTPNotifier) notify(webhook string, user *User, typ NotificationType) {
-	var action string
-	switch typ {
-	case UpdateNotification:
-		action = "update"
-	case DeleteNotification:
-		action = "delete"
-	case AddNotification:
-		action = "add"
-	default:
-		n.lg.Fatal().Int("typ", int(typ)).Msg("logic error, unexpected typ value")
+func (n *HTTPNotifier) notify(ctx context.Context, webHook WebHook, notif *notification) {
+	attempts, err := n.deliver(ctx, webHook, notif)
+	if err != nil {
+		// the notification is dead lettered even when the notifier is stopping, as it's lost otherwise.
+		_ = n.recordDeadLetter(context.Background(), newDeadLetter(webHook, notif), attempts, err)
+	}
+}
+
+// Deliver posts an outbox event to all the webhooks concurrently, so that a slow webhook only delays the event by its
+// own delivery time. Deliveries failing for good are dead lettered, or the error of the first one is returned when
+// there is no dead letter recorder, the event being then delivered again to all the webhooks.
+func (n *HTTPNotifier) Deliver(ctx context.Context, event *OutboxEvent) error {
+	notif := event.notification()
+	errs := make([]error, len(n.webHooks))
+	wg := &sync.WaitGroup{}
+	for i := range n.webHooks {
+		wg.Add(1)
+		go func(i int, webHook WebHook) {
+			defer wg.Done()
+			attempts, err := n.deliver(ctx, webHook, notif)
+			if err == nil {
+				return
+			}
+			if ctx.Err() == nil {
+				letter := newDeadLetter(webHook, notif)
+				letter.OutboxEventID = &event.ID
+				if n.recordDeadLetter(ctx, letter, attempts, err) {
+					return
+				}
+			}
+			errs[i] = fmt.Errorf("delivering to %s/%s: %w", webHook.URL, event.Action, err)
+		}(i, n.webHooks[i])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MaxDeliveryTime returns the longest time Deliver may take, with every attempt timing out. It's unbounded when the
+// http client has no timeout.
+func (n *HTTPNotifier) MaxDeliveryTime() time.Duration {
+	if n.client.Timeout <= 0 {
+		return math.MaxInt64
+	}
+
+	return n.retryPolicy.maxDeliveryTime(n.client.Timeout)
+}
+
+// recordDeadLetter records letter as failed after the given attempts with err, and reports whether it was recorded.
+func (n *HTTPNotifier) recordDeadLetter(ctx context.Context, letter *DeadLetter, attempts int, err error) bool {
+	if n.deadLetters == nil {
+		return false
+	}
+
+	letter.Attempts, letter.LastError = attempts, err.Error()
+	if err = n.deadLetters.RecordDeadLetter(ctx, letter); err != nil {
+		n.lg.Err(err).Str("webhook", letter.Webhook).Msg("recording dead letter")
+
+		return false
+	}
+	n.lg.Info().Str("webhook", letter.Webhook).Uint64("dead_letter_id", letter.ID).Msg("recorded dead letter")
+
+	return true
+}
+
+// webHook returns the configured webhook of url, or an unsigned one in the legacy format when it's not configured
+// anymore.
+func (n *HTTPNotifier) webHook(url string) WebHook {
+	for _, webHook := range n.webHooks {
+		if webHook.URL == url {
+			return webHook
+		}
+	}
+
+	return WebHook{URL: url}
+}
+
+// deliver posts notif to webHook, retrying the failed attempts according to the retry policy, and returns the number
+// of attempts along with the error of the last one.
+func (n *HTTPNotifier) deliver(ctx context.Context, webHook WebHook, notif *notification) (int, error) {
+	for attempt := 1; ; attempt++ {
+		delay, retry, err := n.post(ctx, webHook, notif, attempt)
+		if err == nil || !retry {
+			return attempt, err
+		}
+		if attempt >= n.retryPolicy.MaxAttempts {
+			n.lg.Error().Str("webhook", webHook.URL).Str("event_id", notif.id).Int("attempts", attempt).
+				Msg("giving up post request to webhook")
+
+			return attempt, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return attempt, ctx.Err()
+		}
 	}
+}
 
-	url := webhook + "/" + action
-	jsonStr, err := json.Marshal(user)
+// post makes the given attempt of posting notif to webHook in its format, and returns its error. It reports whether a
+// failed attempt is worth retrying, along with the delay before the retry.
+func (n *HTTPNotifier) post(
+	ctx context.Context, webHook WebHook, notif *notification, attempt int,
+) (time.Duration, bool, error) {
+	path, payload, header, err := webHook.Format.encode(notif)
 	if err != nil {
-		n.lg.Err(err).Str("url", url).Msg("marshaling post data to webhook ")
+		n.lg.Err(err).Str("webhook", webHook.URL).Msg("encoding post data to webhook")
 
-		return
+		return 0, false, err
 	}
+	url := webHook.URL + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		n.lg.Err(err).Str("url", url).Msg("creating post request to webhook")
 
-	//nolint
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonStr))
+		return 0, false, err
+	}
+	req.Header = header
+	if len(webHook.Secrets) != 0 {
+		// every attempt is signed anew, so that retries don't fall out of the receivers tolerance.
+		event := &webhook.Event{
+			ID:      header.Get(webhook.IDHeader),
+			Type:    header.Get(webhook.TypeHeader),
+			Subject: header.Get(webhook.SubjectHeader),
+			Body:    payload,
+		}
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(time.Now(), event, webHook.Secrets...))
+	}
+
+	resp, err := n.client.Do(req)
 	if err != nil {
-		n.lg.Err(err).Str("url", url).Msg("firing post request to webhook")
+		if ctx.Err() != nil {
+			n.lg.Err(err).Str("url", url).Msg("post request to webhook canceled")
 
-		return
+			return 0, false, err
+		}
+		n.lg.Err(err).Str("url", url).Int("attempt", attempt).Msg("firing post request to webhook")
+
+		return n.retryPolicy.backoff(attempt), true, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		n.lg.Err(err).Str("url", url).Str("status", resp.Status).Msg("none ok post request to webhook")
+	// the body is drained so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
 
-		return
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		n.lg.Info().Str("url", url).Msg("post request to webhook successful")
+
+		return 0, false, nil
 	}
-	n.lg.Info().Str("url", url).Msg("post request to webhook successful")
+	err = fmt.Errorf("webhook responded with status %s", resp.Status)
+	if !retryableStatus(resp.StatusCode) {
+		n.lg.Error().Str("url", url).Str("status", resp.Status).Msg("rejected post request to webhook")
+
+		return 0, false, err
+	}
+
+	n.lg.Error().Str("url", url).Str("status", resp.Status).Int("attempt", attempt).Msg("none ok post request to webhook")
+	delay := n.retryPolicy.backoff(attempt)
+	if after, ok := retryAfter(resp, time.Now()); ok {
+		delay = after
+		if delay > n.retryPolicy.MaxBackoff {
+			delay = n.retryPolicy.MaxBackoff
+		}
+	}
+
+	return delay, true, err
 }
 
+// Notify queues the notification of user to all the webhooks, as a single event sharing its id and time.
 func (n *HTTPNotifier) Notify(user *User, typ NotificationType) {
-	for _, webHook := range n.webHooks {
-		n.queue <- queueMessage{
-			webHook: webHook,
-			user:    user,
-			typ:     typ,
+	action := typ.String()
+	if action == "" {
+		n.lg.Fatal().Int("typ", int(typ)).Msg("logic error, unexpected typ value")
+	}
+
+	jsonStr, err := json.Marshal(user.Public())
+	if err != nil {
+		n.lg.Err(err).Str("user_id", user.ID).Msg("marshaling post data to webhook ")
+
+		return
+	}
+	notif := &notification{id: uuid.New().String(), action: action, userID: user.ID, time: time.Now(), data: jsonStr}
+
+	for i, webHook := range n.webHooks {
+		select {
+		case n.queues[i] <- notif:
+		default:
+			// blocking would stall the caller, which already committed the change.
+			n.lg.Error().Str("webhook", webHook.URL).Str("event_id", notif.id).Msg("notification queue full")
+			_ = n.recordDeadLetter(context.Background(), newDeadLetter(webHook, notif), 0, errQueueFull)
 		}
 	}
 }
 
-var _ Notifier = &HTTPNotifier{}
+var (
+	_ Notifier  = &HTTPNotifier{}
+	_ Deliverer = &HTTPNotifier{}
+)
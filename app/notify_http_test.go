@@ -1,16 +1,22 @@
 package app_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/sir-hassan/grpc-service-user/app"
+	"github.com/sir-hassan/grpc-service-user/webhook"
 )
 
 func TestHTTPNotifier_Notify(t *testing.T) {
@@ -26,7 +32,8 @@ func TestHTTPNotifier_Notify(t *testing.T) {
 	}))
 	defer svr.Close()
 
-	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, []string{svr.URL}, 10)
+	webHooks := []app.WebHook{{URL: svr.URL}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
 	cancelNotifierChan := make(chan any)
 	doneNotifierChan := notifier.Start(cancelNotifierChan)
 
@@ -49,14 +56,267 @@ func TestHTTPNotifier_Notify(t *testing.T) {
 
 	expectHTTPCalls := []string{
 		//nolint
-		`/add -> {"ID":"111","FirstName":"","LastName":"","Nickname":"","Password":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
+		`/add -> {"ID":"111","FirstName":"","LastName":"","Nickname":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
 		//nolint
-		`/update -> {"ID":"222","FirstName":"","LastName":"","Nickname":"","Password":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
+		`/update -> {"ID":"222","FirstName":"","LastName":"","Nickname":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
 		//nolint
-		`/delete -> {"ID":"333","FirstName":"","LastName":"","Nickname":"","Password":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
+		`/delete -> {"ID":"333","FirstName":"","LastName":"","Nickname":"","Email":"","Country":"","CreatedAt":"0001-01-01T00:00:00Z","UpdatedAt":"0001-01-01T00:00:00Z"}`,
 	}
 
 	if !reflect.DeepEqual(webHookCalls, expectHTTPCalls) {
 		t.Errorf("Unexpected webhook calls = %v, want %v", webHookCalls, expectHTTPCalls)
 	}
 }
+
+func TestHTTPNotifier_Retry(t *testing.T) {
+	lock := &sync.Mutex{}
+	attempts := map[string][]time.Time{}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts[r.URL.Path] = append(attempts[r.URL.Path], time.Now())
+		count := len(attempts[r.URL.Path])
+
+		switch r.URL.Path {
+		case "/add":
+			// fails intermittently, succeeding on the third attempt.
+			if count < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+		case "/update":
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		case "/delete":
+			if count == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+
+				return
+			}
+		case "/restore":
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer svr.Close()
+
+	policy := app.RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	webHooks := []app.WebHook{{URL: svr.URL}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, policy, nil)
+	cancelNotifierChan := make(chan any)
+	doneNotifierChan := notifier.Start(cancelNotifierChan)
+
+	for _, typ := range []app.NotificationType{
+		app.AddNotification, app.UpdateNotification, app.DeleteNotification, app.RestoreNotification,
+	} {
+		notifier.Notify(&app.User{ID: "111"}, typ)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+
+	close(cancelNotifierChan)
+	<-doneNotifierChan
+
+	lock.Lock()
+	defer lock.Unlock()
+	want := map[string]int{"/add": 3, "/update": 1, "/delete": 2, "/restore": 4}
+	for path, count := range want {
+		if got := len(attempts[path]); got != count {
+			t.Errorf("%s webhook got %d attempts, want %d", path, got, count)
+		}
+	}
+	// Retry-After is honored, capped by the max backoff.
+	if d := attempts["/delete"]; len(d) == 2 && d[1].Sub(d[0]) < 40*time.Millisecond {
+		t.Errorf("/delete webhook retried after %v, want the max backoff", d[1].Sub(d[0]))
+	}
+}
+
+func TestHTTPNotifier_Cancel(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer svr.Close()
+
+	policy := app.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Hour
+	webHooks := []app.WebHook{{URL: svr.URL}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, policy, nil)
+	cancelNotifierChan := make(chan any)
+	doneNotifierChan := notifier.Start(cancelNotifierChan)
+
+	notifier.Notify(&app.User{ID: "111"}, app.AddNotification)
+	time.Sleep(time.Millisecond * 50)
+
+	// stopping the notifier interrupts the backoff.
+	close(cancelNotifierChan)
+	select {
+	case <-doneNotifierChan:
+	case <-time.After(time.Second):
+		t.Fatal("notifier didn't stop while backing off")
+	}
+}
+
+func TestHTTPNotifier_Deliver(t *testing.T) {
+	lock := &sync.Mutex{}
+	var webHookCalls []string
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		webHookCalls = append(webHookCalls, r.URL.Path+" -> "+string(data))
+		if strings.HasPrefix(r.URL.Path, "/rejecting") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer svr.Close()
+
+	webHooks := []app.WebHook{{URL: svr.URL}, {URL: svr.URL + "/rejecting"}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
+	event := &app.OutboxEvent{Action: "add", UserID: "111", Payload: []byte(`{"ID":"111"}`)}
+
+	err := notifier.Deliver(context.Background(), event)
+	if err == nil || !strings.Contains(err.Error(), "/rejecting/add") {
+		t.Errorf("Deliver() with a rejecting webhook: unexpected error: %v", err)
+	}
+	// the webhooks are posted to concurrently, in no particular order.
+	sort.Strings(webHookCalls)
+	expectHTTPCalls := []string{`/add -> {"ID":"111"}`, `/rejecting/add -> {"ID":"111"}`}
+	if !reflect.DeepEqual(webHookCalls, expectHTTPCalls) {
+		t.Errorf("Unexpected webhook calls = %v, want %v", webHookCalls, expectHTTPCalls)
+	}
+}
+
+func TestHTTPNotifier_DeliverConcurrently(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer svr.Close()
+
+	webHooks := []app.WebHook{{URL: svr.URL + "/first"}, {URL: svr.URL + "/second"}, {URL: svr.URL + "/third"}}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
+	event := &app.OutboxEvent{Action: "add", UserID: "111", Payload: []byte(`{"ID":"111"}`)}
+
+	// a slow webhook only delays the event by its own delivery time.
+	start := time.Now()
+	if err := notifier.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver() unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Deliver() to 3 webhooks taking 200ms each took %v", elapsed)
+	}
+}
+
+func TestHTTPNotifier_MaxDeliveryTime(t *testing.T) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, client, nil, 10, app.DefaultRetryPolicy(), nil)
+
+	// 5 attempts timing out, with 500ms, 1s, 2s and 4s of backoff in between.
+	if got, want := notifier.MaxDeliveryTime(), 57500*time.Millisecond; got != want {
+		t.Errorf("MaxDeliveryTime() = %v, want %v", got, want)
+	}
+}
+
+func TestHTTPNotifier_Signature(t *testing.T) {
+	oldSecret, newSecret := []byte("old-secret"), []byte("new-secret")
+	lock := &sync.Mutex{}
+	verifyErrs := map[string]error{}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		// the receiver already switched to the new secret.
+		_, verifyErrs[r.URL.Path] = webhook.VerifyRequest(r, webhook.DefaultTolerance, newSecret)
+	}))
+	defer svr.Close()
+
+	webHooks := []app.WebHook{
+		{URL: svr.URL + "/signed", Secrets: [][]byte{oldSecret, newSecret}},
+		{URL: svr.URL + "/unsigned"},
+		{URL: svr.URL + "/structured", Format: app.CloudEventsStructuredFormat, Secrets: [][]byte{newSecret}},
+		{URL: svr.URL + "/binary", Format: app.CloudEventsBinaryFormat, Secrets: [][]byte{newSecret}},
+	}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
+	event := &app.OutboxEvent{Action: "add", UserID: "111", Payload: []byte(`{"ID":"111"}`)}
+	if err := notifier.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver() unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"/signed/add", "/structured", "/binary"} {
+		if err, ok := verifyErrs[path]; !ok || err != nil {
+			t.Errorf("signed webhook %s: unexpected verify error: %v", path, err)
+		}
+	}
+	if err := verifyErrs["/unsigned/add"]; !errors.Is(err, webhook.ErrMissingSignature) {
+		t.Errorf("unsigned webhook: verify error = %v, want %v", err, webhook.ErrMissingSignature)
+	}
+}
+
+func TestHTTPNotifier_CloudEvents(t *testing.T) {
+	lock := &sync.Mutex{}
+	requests := map[string]*http.Request{}
+	bodies := map[string]string{}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		requests[r.URL.Path], bodies[r.URL.Path] = r, string(data)
+	}))
+	defer svr.Close()
+
+	webHooks := []app.WebHook{
+		{URL: svr.URL + "/structured", Format: app.CloudEventsStructuredFormat},
+		{URL: svr.URL + "/binary", Format: app.CloudEventsBinaryFormat},
+		{URL: svr.URL + "/legacy"},
+	}
+	notifier := app.NewHTTPNotifier(zerolog.Logger{}, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
+	eventTime := time.Date(2022, 11, 2, 10, 30, 0, 0, time.UTC)
+	event := &app.OutboxEvent{
+		ID: 1, EventID: "event-1", Action: "update", UserID: "111", Payload: []byte(`{"ID":"111"}`), CreatedAt: eventTime,
+	}
+	if err := notifier.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver() unexpected error: %v", err)
+	}
+
+	if got := requests["/structured"].Header.Get("Content-Type"); got != "application/cloudevents+json" {
+		t.Errorf("structured mode content type = %q", got)
+	}
+	var structured map[string]any
+	if err := json.Unmarshal([]byte(bodies["/structured"]), &structured); err != nil {
+		t.Fatalf("unmarshal structured event: %v", err)
+	}
+	wantStructured := map[string]any{
+		"specversion": "1.0", "id": "event-1", "source": app.CloudEventsSource, "type": "user.updated",
+		"subject": "111", "time": "2022-11-02T10:30:00Z", "datacontenttype": "application/json",
+		"data": map[string]any{"ID": "111"},
+	}
+	if !reflect.DeepEqual(structured, wantStructured) {
+		t.Errorf("structured event = %v, want %v", structured, wantStructured)
+	}
+
+	binary := requests["/binary"].Header
+	if binary.Get("ce-id") != "event-1" || binary.Get("ce-type") != "user.updated" || binary.Get("ce-subject") != "111" ||
+		binary.Get("ce-specversion") != "1.0" || binary.Get("ce-time") != "2022-11-02T10:30:00Z" {
+		t.Errorf("unexpected binary mode headers: %v", binary)
+	}
+	if bodies["/binary"] != `{"ID":"111"}` || binary.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected binary mode body: %q", bodies["/binary"])
+	}
+
+	if bodies["/legacy/update"] != `{"ID":"111"}` || requests["/legacy/update"].Header.Get("ce-id") != "" {
+		t.Errorf("unexpected legacy request: %q", bodies["/legacy/update"])
+	}
+}
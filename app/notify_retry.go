@@ -0,0 +1,92 @@
+package app
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how HTTPNotifier retries failed webhook deliveries. Network errors, 5xx responses, 408 and 429
+// are retried, while the other 4xx responses are dropped right away: the webhook would reject the same request again.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts of a delivery, the first one included. A non-positive value means a
+	// single attempt.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, multiplied by Multiplier after every retry up to MaxBackoff.
+	// MaxBackoff also caps the delays asked by webhooks with the Retry-After header, so that a webhook can't stall the
+	// notifier.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff randomly cut from it, so that retries get spread over time.
+	Jitter float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		//nolint
+		MaxAttempts: 5,
+		//nolint
+		InitialBackoff: 500 * time.Millisecond,
+		//nolint
+		MaxBackoff: 30 * time.Second,
+		//nolint
+		Multiplier: 2,
+		//nolint
+		Jitter: 0.2,
+	}
+}
+
+// backoff returns the delay before the given retry, counted from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := math.Min(float64(p.InitialBackoff)*math.Pow(p.Multiplier, float64(retry-1)), float64(p.MaxBackoff))
+	//nolint
+	backoff -= backoff * p.Jitter * rand.Float64()
+
+	return time.Duration(backoff)
+}
+
+// maxDeliveryTime returns the longest time a delivery may take with every attempt timing out after timeout, the
+// backoffs being at most MaxBackoff however long the Retry-After headers ask for.
+func (p RetryPolicy) maxDeliveryTime(timeout time.Duration) time.Duration {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	total := time.Duration(attempts) * timeout
+	for retry := 1; retry < attempts; retry++ {
+		total += time.Duration(math.Min(
+			float64(p.InitialBackoff)*math.Pow(p.Multiplier, float64(retry-1)), float64(p.MaxBackoff)))
+	}
+
+	return total
+}
+
+// retryableStatus reports whether a delivery answered with the given status code is worth retrying.
+func retryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests
+}
+
+// retryAfter returns the delay asked by the Retry-After header of resp, either a number of seconds or an http date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+
+		return 0, true
+	}
+
+	return 0, false
+}
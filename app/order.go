@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"gorm.io/gorm/clause"
+)
+
+// userSortColumns maps the api field names ListUsers can be sorted by to their database columns.
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"nickname":   "nickname",
+	"email":      "email",
+	"country":    "country",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// defaultOrder is used when ListUsers is called without any order.
+var defaultOrder = []*api.OrderBy{{Field: "created_at"}}
+
+type sortKey struct {
+	field  string
+	column string
+	desc   bool
+}
+
+// sortKeys validates the requested order and returns the corresponding sort keys, always ending with id so that the
+// order is total and pagination deterministic.
+func sortKeys(orderBy []*api.OrderBy) ([]sortKey, error) {
+	if len(orderBy) == 0 {
+		orderBy = defaultOrder
+	}
+
+	keys := make([]sortKey, 0, len(orderBy)+1)
+	seen := map[string]bool{}
+	for i, o := range orderBy {
+		column, ok := userSortColumns[o.Field]
+		if !ok {
+			return nil, invalidArgumentError(fmt.Sprintf("order_by[%d]", i), fmt.Sprintf(
+				"invalid sort field 'order_by[%d]' %q, valid fields are: %s", i, o.Field, strings.Join(validSortFields(), ", "),
+			))
+		}
+		if seen[o.Field] {
+			return nil, invalidArgumentError(fmt.Sprintf("order_by[%d]", i), fmt.Sprintf("duplicate sort field %q", o.Field))
+		}
+		seen[o.Field] = true
+		keys = append(keys, sortKey{field: o.Field, column: column, desc: o.Descending})
+	}
+
+	if !seen["id"] {
+		keys = append(keys, sortKey{field: "id", column: "id"})
+	}
+
+	return keys, nil
+}
+
+func orderClause(keys []sortKey) clause.OrderBy {
+	columns := make([]clause.OrderByColumn, len(keys))
+	for i, k := range keys {
+		columns[i] = clause.OrderByColumn{Column: clause.Column{Name: k.column}, Desc: k.desc}
+	}
+
+	return clause.OrderBy{Columns: columns}
+}
+
+func validSortFields() []string {
+	fields := make([]string, 0, len(userSortColumns))
+	for field := range userSortColumns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// value returns the value of the sort key in u.
+func (k sortKey) value(u *User) any {
+	switch k.field {
+	case "id":
+		return u.ID
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "nickname":
+		return u.Nickname
+	case "email":
+		return u.Email
+	case "country":
+		return u.Country
+	case "created_at":
+		return u.CreatedAt
+	case "updated_at":
+		return u.UpdatedAt
+	default:
+		panic(fmt.Sprintf("logic error, unexpected sort field: %s", k.field))
+	}
+}
@@ -0,0 +1,103 @@
+package app_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserStore_ListUsers_OrderBy(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []app.User{
+		{ID: "3", FirstName: "Bob", Country: "DE", CreatedAt: base},
+		{ID: "1", FirstName: "Anna", Country: "FR", CreatedAt: base.Add(time.Hour)},
+		{ID: "4", FirstName: "Carl", Country: "DE", CreatedAt: base.Add(time.Hour)},
+		{ID: "2", FirstName: "Dana", Country: "FR", CreatedAt: base.Add(2 * time.Hour)},
+	}
+	for i := range users {
+		db.Create(&users[i])
+	}
+
+	tests := []struct {
+		name      string
+		orderBy   []*api.OrderBy
+		pageSize  int32
+		page      int32
+		wantIDs   []string
+		wantError string
+	}{
+		{
+			name:    "default order",
+			wantIDs: []string{"3", "1", "4", "2"},
+		},
+		{
+			name:    "single field",
+			orderBy: []*api.OrderBy{{Field: "first_name"}},
+			wantIDs: []string{"1", "3", "4", "2"},
+		},
+		{
+			name:    "descending with id tiebreaker",
+			orderBy: []*api.OrderBy{{Field: "created_at", Descending: true}},
+			wantIDs: []string{"2", "1", "4", "3"},
+		},
+		{
+			name:    "multiple fields",
+			orderBy: []*api.OrderBy{{Field: "country", Descending: true}, {Field: "first_name"}},
+			wantIDs: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "paginated",
+			orderBy:  []*api.OrderBy{{Field: "country"}, {Field: "id", Descending: true}},
+			page:     2,
+			pageSize: 2,
+			wantIDs:  []string{"2", "1"},
+		},
+		{
+			name:      "unknown field",
+			orderBy:   []*api.OrderBy{{Field: "password"}},
+			wantError: "valid fields are: country, created_at, email",
+		},
+		{
+			name:      "duplicate field",
+			orderBy:   []*api.OrderBy{{Field: "email"}, {Field: "email", Descending: true}},
+			wantError: "duplicate sort field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &mockListUsersServer{}
+			err := s.ListUsers(&api.ListUsersRequest{OrderBy: tt.orderBy, Page: tt.page, PageSize: tt.pageSize}, stream)
+			if tt.wantError != "" {
+				if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error on call list users: %v", err)
+			}
+
+			var ids []string
+			for _, u := range stream.users {
+				ids = append(ids, u.Id)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ListUsers() ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// outboxBatchSize is the maximum number of events claimed at once by OutboxDispatcher.
+const outboxBatchSize = 100
+
+// OutboxEvent is a notification of a user change, written in the transaction of the change when the outbox is
+// enabled, and delivered by OutboxDispatcher once committed.
+type OutboxEvent struct {
+	ID uint64 `gorm:"primaryKey"`
+	// EventID is the id of the notification posted to the webhooks.
+	EventID string
+	// Action is the NotificationType of the event, as named in the webhook urls: add, update, delete...
+	Action string
+	UserID string
+	// JSON of the PublicUser, as of the change.
+	Payload []byte
+
+	CreatedAt time.Time
+	// Set once delivered, pending events are delivered in id order.
+	DispatchedAt *time.Time `gorm:"index"`
+	// Set while an OutboxDispatcher delivers the event, the claim expiring in case the dispatcher dies meanwhile.
+	ClaimedUntil *time.Time
+	// Number of failed deliveries, along with the error of the last one.
+	Attempts  int
+	LastError string
+}
+
+// notification returns the notification of the event, the events written before they had an EventID falling back to
+// their id.
+func (e *OutboxEvent) notification() *notification {
+	id := e.EventID
+	if id == "" {
+		id = "outbox-" + strconv.FormatUint(e.ID, 10)
+	}
+
+	return &notification{id: id, action: e.Action, userID: e.UserID, time: e.CreatedAt, data: e.Payload}
+}
+
+// Deliverer delivers outbox events to other systems. Failed events are delivered again by OutboxDispatcher, so
+// deliveries must be idempotent on the receiving side.
+type Deliverer interface {
+	Deliver(ctx context.Context, event *OutboxEvent) error
+}
+
+// WithOutbox makes UserStore write its notifications to the outbox in the transaction of the changes, instead of
+// sending them to its notifier once committed. They must then be delivered by an OutboxDispatcher.
+func WithOutbox() UserStoreOption {
+	return func(s *UserStore) {
+		s.outbox = true
+	}
+}
+
+// enqueueNotifications writes the notifications of users to the outbox within tx, if enabled.
+func (s *UserStore) enqueueNotifications(ctx context.Context, tx *gorm.DB, typ NotificationType, users ...*User) error {
+	if !s.outbox || len(users) == 0 {
+		return nil
+	}
+
+	events := make([]*OutboxEvent, len(users))
+	for i, user := range users {
+		payload, err := json.Marshal(user.Public())
+		if err != nil {
+			return s.internalError(ctx, err, "marshal outbox event in enqueueNotifications func")
+		}
+		events[i] = &OutboxEvent{EventID: uuid.New().String(), Action: typ.String(), UserID: user.ID, Payload: payload}
+	}
+	if err := tx.Create(&events).Error; err != nil {
+		return s.internalError(ctx, err, "insert query in enqueueNotifications func")
+	}
+
+	return nil
+}
+
+// notifyCommitted sends the notifications of users to the notifier, unless they were written to the outbox. It's
+// called once the changes are committed.
+func (s *UserStore) notifyCommitted(typ NotificationType, users ...*User) {
+	if s.outbox {
+		return
+	}
+	for _, user := range users {
+		s.notifier.Notify(user, typ)
+	}
+}
+
+// OutboxDispatcher periodically delivers the pending outbox events, and marks them as dispatched. Events are delivered
+// at least once: an event whose delivery fails is delivered again on the next round, holding back the following ones.
+// Events are claimed for the lease duration before being delivered outside of any transaction, the claim of the
+// remaining events of a batch being renewed before each delivery, so the lease must exceed the delivery time of a
+// single event (see HTTPNotifier.MaxDeliveryTime): other dispatchers deliver the events again once their claim
+// expired. Dispatched events are deleted after the retention period. Like HTTPNotifier, it runs in a goroutine spawned
+// by Start().
+type OutboxDispatcher struct {
+	lg        zerolog.Logger
+	db        *gorm.DB
+	deliverer Deliverer
+	interval  time.Duration
+	lease     time.Duration
+	retention time.Duration
+}
+
+func NewOutboxDispatcher(
+	lg zerolog.Logger, db *gorm.DB, deliverer Deliverer, interval time.Duration, lease time.Duration,
+	retention time.Duration,
+) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		lg:        lg,
+		db:        db,
+		deliverer: deliverer,
+		interval:  interval,
+		lease:     lease,
+		retention: retention,
+	}
+}
+
+func (d *OutboxDispatcher) Start(cancelChan chan any) chan any {
+	doneChan := make(chan any)
+
+	// canceling ctx interrupts the delivery in progress, which is then retried on the next start.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-cancelChan
+		cancel()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+	loop:
+		for {
+			d.dispatchPending(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		d.lg.Info().Msg("outbox dispatcher stopped")
+		close(doneChan)
+	}()
+
+	d.lg.Info().Dur("interval", d.interval).Msg("outbox dispatcher started")
+
+	return doneChan
+}
+
+// dispatchPending delivers batches of events until there are no more pending events, a delivery fails or it gets
+// canceled, then deletes the expired dispatched events.
+func (d *OutboxDispatcher) dispatchPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		count, done, err := d.dispatch(ctx)
+		if err != nil {
+			d.lg.Err(err).Msg("dispatching outbox events")
+
+			return
+		}
+		if count != 0 {
+			d.lg.Info().Int("count", count).Msg("dispatched outbox events")
+		}
+		if done {
+			break
+		}
+	}
+
+	res := d.db.WithContext(ctx).Where("dispatched_at < ?", time.Now().Add(-d.retention)).Delete(&OutboxEvent{})
+	if res.Error != nil {
+		d.lg.Err(res.Error).Msg("deleting dispatched outbox events")
+	}
+}
+
+// dispatch claims a batch of pending events, delivers them in order, and marks the delivered ones as dispatched. The
+// claim of the undelivered events is renewed before each delivery, so that a slow batch doesn't outlive its lease. It
+// stops at the first failing event, whose failure is recorded, and releases the claim of the undelivered events. It
+// returns how many events were delivered, and whether there is no point in dispatching another batch right away.
+func (d *OutboxDispatcher) dispatch(ctx context.Context) (int, bool, error) {
+	events, err := d.claim(ctx)
+	if err != nil {
+		return 0, true, err
+	}
+
+	// the claim is released even when canceled, so that the events don't wait for the lease to expire.
+	db := d.db.WithContext(context.Background())
+	for i := range events {
+		event := &events[i]
+		if i != 0 {
+			if err = d.renew(db, events[i:]); err != nil {
+				return i, true, err
+			}
+		}
+		if err = d.deliverer.Deliver(ctx, event); err != nil {
+			d.lg.Err(err).Uint64("event_id", event.ID).Msg("delivering outbox event")
+			err = db.Model(event).Updates(map[string]any{
+				"attempts":      gorm.Expr("attempts + 1"),
+				"last_error":    err.Error(),
+				"claimed_until": nil,
+			}).Error
+			if err == nil {
+				err = d.release(db, events[i+1:])
+			}
+
+			return i, true, err
+		}
+		err = db.Model(event).Updates(map[string]any{"dispatched_at": time.Now(), "claimed_until": nil}).Error
+		if err != nil {
+			return i, true, err
+		}
+	}
+
+	return len(events), len(events) < outboxBatchSize, nil
+}
+
+// claim returns a batch of pending events, claimed for the lease duration in a short transaction so that other
+// dispatchers skip them meanwhile.
+func (d *OutboxDispatcher) claim(ctx context.Context) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		query := tx.Where("dispatched_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)", now).
+			Order("id").Limit(outboxBatchSize)
+		if tx.Dialector.Name() == "postgres" {
+			// the dispatchers of other instances skip the events being claimed, instead of claiming them too.
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		if err := query.Find(&events).Error; err != nil || len(events) == 0 {
+			return err
+		}
+
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", eventIDs(events)).Update("claimed_until", now.Add(d.lease)).Error
+	})
+
+	return events, err
+}
+
+// renew extends the claim of events for another lease duration.
+func (d *OutboxDispatcher) renew(db *gorm.DB, events []OutboxEvent) error {
+	return db.Model(&OutboxEvent{}).Where("id IN ?", eventIDs(events)).
+		Update("claimed_until", time.Now().Add(d.lease)).Error
+}
+
+// release releases the claim of events, which are then delivered on the next round.
+func (d *OutboxDispatcher) release(db *gorm.DB, events []OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return db.Model(&OutboxEvent{}).Where("id IN ?", eventIDs(events)).Update("claimed_until", nil).Error
+}
+
+func eventIDs(events []OutboxEvent) []uint64 {
+	ids := make([]uint64, len(events))
+	for i := range events {
+		ids[i] = events[i].ID
+	}
+
+	return ids
+}
@@ -0,0 +1,196 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type mockDeliverer struct {
+	lock      sync.Mutex
+	failures  int
+	delivered []string
+	// Called before every delivery, if not nil.
+	onDeliver func(event *app.OutboxEvent)
+}
+
+func (d *mockDeliverer) Deliver(_ context.Context, event *app.OutboxEvent) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.onDeliver != nil {
+		d.onDeliver(event)
+	}
+
+	if d.failures > 0 {
+		d.failures--
+
+		return errors.New("webhook down")
+	}
+	d.delivered = append(d.delivered, event.Action+":"+event.UserID)
+
+	return nil
+}
+
+func (d *mockDeliverer) deliveredEvents() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]string{}, d.delivered...)
+}
+
+func TestUserStore_Outbox(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	notifier := app.NewMockedNotifier()
+	s := app.NewUserStore(db, notifier, zerolog.Logger{}, app.WithOutbox())
+	ctx := context.Background()
+
+	id := makeUser(t, s, "first@example.com")
+	if _, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id}); err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+	// the events of rolled back changes are rolled back along with them.
+	_, err = s.AddUser(ctx, &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "first@example.com"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("AddUser() with conflict: want AlreadyExists, got: %v", err)
+	}
+
+	if count := notifier.ActionCallsCount("add") + notifier.ActionCallsCount("delete"); count != 0 {
+		t.Errorf("outbox changes notified the notifier %d times", count)
+	}
+	var events []app.OutboxEvent
+	if err = db.Order("id").Find(&events).Error; err != nil {
+		t.Fatalf("select outbox events: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != "add" || events[1].Action != "delete" || events[1].UserID != id {
+		t.Fatalf("unexpected outbox events: %v", events)
+	}
+	if string(events[1].Payload) == "" || events[1].DispatchedAt != nil {
+		t.Errorf("unexpected outbox event: %v", events[1])
+	}
+}
+
+func TestOutboxDispatcher(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithOutbox())
+	first := makeUser(t, s, "first@example.com")
+	second := makeUser(t, s, "second@example.com")
+
+	// the first delivery fails, holding back the second event until it's delivered again.
+	deliverer := &mockDeliverer{failures: 1}
+	dispatcher := app.NewOutboxDispatcher(zerolog.Logger{}, db, deliverer, 10*time.Millisecond, time.Minute, time.Hour)
+	cancelChan := make(chan any)
+	doneChan := dispatcher.Start(cancelChan)
+
+	want := []string{"add:" + first, "add:" + second}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(deliverer.deliveredEvents(), want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(cancelChan)
+	<-doneChan
+
+	if got := deliverer.deliveredEvents(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered events = %v, want %v", got, want)
+	}
+	var events []app.OutboxEvent
+	if err = db.Order("id").Find(&events).Error; err != nil {
+		t.Fatalf("select outbox events: %v", err)
+	}
+	for _, e := range events {
+		if e.DispatchedAt == nil || e.ClaimedUntil != nil {
+			t.Errorf("delivered event not marked as dispatched, or still claimed: %v", e)
+		}
+	}
+	if events[0].Attempts != 1 || events[0].LastError != "webhook down" || events[1].Attempts != 0 {
+		t.Errorf("unexpected failed delivery records: %v", events)
+	}
+}
+
+func TestOutboxDispatcher_Claim(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithOutbox())
+	id := makeUser(t, s, "first@example.com")
+
+	dispatch := func(claimedUntil time.Time) []string {
+		err := db.Model(&app.OutboxEvent{}).Where("1 = 1").Update("claimed_until", claimedUntil).Error
+		if err != nil {
+			t.Fatalf("claim outbox events: %v", err)
+		}
+		deliverer := &mockDeliverer{}
+		cancelChan := make(chan any)
+		doneChan := app.NewOutboxDispatcher(zerolog.Logger{}, db, deliverer, time.Hour, time.Minute, time.Hour).
+			Start(cancelChan)
+		time.Sleep(50 * time.Millisecond)
+		close(cancelChan)
+		<-doneChan
+
+		return deliverer.deliveredEvents()
+	}
+
+	// the events claimed by another dispatcher are skipped until their claim expires.
+	if got := dispatch(time.Now().Add(time.Hour)); len(got) != 0 {
+		t.Errorf("dispatcher delivered claimed events: %v", got)
+	}
+	if got := dispatch(time.Now().Add(-time.Second)); !reflect.DeepEqual(got, []string{"add:" + id}) {
+		t.Errorf("dispatcher delivered %v after the claim expired, want the add of %s", got, id)
+	}
+}
+
+func TestOutboxDispatcher_RenewClaim(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithOutbox())
+	for _, email := range []string{"first@example.com", "second@example.com", "third@example.com"} {
+		makeUser(t, s, email)
+	}
+
+	// every delivery takes longer than the lease, which the claim must outlive.
+	lease := 100 * time.Millisecond
+	var expired []uint64
+	deliverer := &mockDeliverer{onDeliver: func(event *app.OutboxEvent) {
+		var claimed app.OutboxEvent
+		if err := db.First(&claimed, event.ID).Error; err != nil {
+			t.Errorf("select outbox event: %v", err)
+		}
+		if claimed.ClaimedUntil == nil || claimed.ClaimedUntil.Before(time.Now()) {
+			expired = append(expired, event.ID)
+		}
+		time.Sleep(2 * lease)
+	}}
+	cancelChan := make(chan any)
+	doneChan := app.NewOutboxDispatcher(zerolog.Logger{}, db, deliverer, time.Hour, lease, time.Hour).Start(cancelChan)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(deliverer.deliveredEvents()) != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(cancelChan)
+	<-doneChan
+
+	if got := deliverer.deliveredEvents(); len(got) != 3 {
+		t.Fatalf("delivered events = %v, want 3 events", got)
+	}
+	if len(expired) != 0 {
+		t.Errorf("events %v were delivered after their claim expired", expired)
+	}
+}
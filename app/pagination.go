@@ -0,0 +1,179 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPageSize = 10
+
+	// nextPageTokenHeader is the ListUsers header metadata key carrying the next page token.
+	nextPageTokenHeader = "next-page-token"
+)
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken is the content of the opaque ListUsers page tokens. It points right after the last user of the previous
+// page (keyset pagination), and is bound to the filters and order of the request it was issued for.
+type pageToken struct {
+	Query  string   `json:"q"`
+	Values []string `json:"v"`
+}
+
+// pageTokenCodec encodes page tokens as base64(json).base64(hmac), so that clients can't forge or alter them.
+type pageTokenCodec struct {
+	key []byte
+}
+
+func newPageTokenCodec(key []byte) *pageTokenCodec {
+	return &pageTokenCodec{key: key}
+}
+
+// randomKey returns a random HMAC key, used when no secret is configured. Page tokens and idempotency records keyed
+// with it are only valid for the lifetime of the process.
+func randomKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generating random key: %v", err))
+	}
+
+	return key
+}
+
+// WithPageTokenSecret sets the key used to sign page tokens. All the instances of the service must share the same
+// secret for tokens to be usable across them, a random one is generated otherwise.
+func WithPageTokenSecret(secret []byte) UserStoreOption {
+	return func(s *UserStore) {
+		s.pageTokens = newPageTokenCodec(secret)
+	}
+}
+
+func (c *pageTokenCodec) encode(t pageToken) string {
+	payload, _ := json.Marshal(t)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+func (c *pageTokenCodec) decode(token string) (pageToken, error) {
+	t := pageToken{}
+
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return t, errInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return t, errInvalidPageToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, c.sign(payload)) {
+		return t, errInvalidPageToken
+	}
+	if err = json.Unmarshal(payload, &t); err != nil {
+		return t, errInvalidPageToken
+	}
+
+	return t, nil
+}
+
+func (c *pageTokenCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// listQueryHash identifies the filters, order and scope of a ListUsers request, so a token can't be replayed against
+// another query.
+func listQueryHash(req *api.ListUsersRequest) string {
+	query, _ := proto.MarshalOptions{Deterministic: true}.Marshal(&api.ListUsersRequest{
+		Filters:        req.Filters,
+		Where:          req.Where,
+		OrderBy:        req.OrderBy,
+		IncludeDeleted: req.IncludeDeleted,
+	})
+	sum := sha256.Sum256(query)
+
+	//nolint
+	return hex.EncodeToString(sum[:12])
+}
+
+// normalizePage applies the defaults to the page number and size.
+func normalizePage(page int32, pageSize int32) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+
+	return int(page), int(pageSize)
+}
+
+// tokenValues encodes the sort key values of u, to be stored in a page token.
+func tokenValues(keys []sortKey, u *User) []string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		switch v := k.value(u).(type) {
+		case time.Time:
+			values[i] = v.UTC().Format(time.RFC3339Nano)
+		case string:
+			values[i] = v
+		}
+	}
+
+	return values
+}
+
+// keysetCondition returns the condition selecting the users sorted after the ones having the given sort key values:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... with '<' in place of '>' for descending keys.
+func keysetCondition(keys []sortKey, values []string) (clause.Expression, error) {
+	if len(values) != len(keys) {
+		return nil, errInvalidPageToken
+	}
+
+	typed := make([]any, len(values))
+	for i, k := range keys {
+		typed[i] = values[i]
+		if userFilterFields[k.field].kind == timeField {
+			t, err := time.Parse(time.RFC3339Nano, values[i])
+			if err != nil {
+				return nil, errInvalidPageToken
+			}
+			typed[i] = t
+		}
+	}
+
+	var or []clause.Expression
+	for i, k := range keys {
+		and := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: clause.Column{Name: keys[j].column}, Value: typed[j]})
+		}
+		if k.desc {
+			and = append(and, clause.Lt{Column: clause.Column{Name: k.column}, Value: typed[i]})
+		} else {
+			and = append(and, clause.Gt{Column: clause.Column{Name: k.column}, Value: typed[i]})
+		}
+		or = append(or, clause.And(and...))
+	}
+	if len(or) == 1 {
+		// gorm joins a lone OR condition to the other conditions with OR, so it must not be wrapped.
+		return or[0], nil
+	}
+
+	return clause.Or(or...), nil
+}
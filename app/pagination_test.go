@@ -0,0 +1,231 @@
+package app_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func nextPageToken(stream *mockListUsersServer) string {
+	if values := stream.header.Get("next-page-token"); len(values) != 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+func TestUserStore_ListUsers_PageToken(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithPageTokenSecret([]byte("secret")))
+
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		db.Create(&app.User{
+			ID:        strconv.Itoa(100 + i),
+			FirstName: "name_" + strconv.Itoa(i%4),
+			Country:   []string{"DE", "FR"}[i%2],
+			// a few users share the same creation time.
+			CreatedAt: base.Add(time.Duration(i/3) * time.Minute),
+		})
+	}
+
+	tests := []struct {
+		name      string
+		req       *api.ListUsersRequest
+		wantCount int
+	}{
+		{
+			name:      "default order",
+			req:       &api.ListUsersRequest{PageSize: 4},
+			wantCount: 25,
+		},
+		{
+			name:      "descending time",
+			req:       &api.ListUsersRequest{PageSize: 3, OrderBy: []*api.OrderBy{{Field: "created_at", Descending: true}}},
+			wantCount: 25,
+		},
+		{
+			name: "mixed order",
+			req: &api.ListUsersRequest{PageSize: 5, OrderBy: []*api.OrderBy{
+				{Field: "first_name", Descending: true}, {Field: "created_at"},
+			}},
+			wantCount: 25,
+		},
+		{
+			name:      "filtered",
+			req:       &api.ListUsersRequest{PageSize: 2, Filters: map[string]string{"country": "FR"}},
+			wantCount: 12,
+		},
+		{
+			name:      "ordered by id only",
+			req:       &api.ListUsersRequest{PageSize: 7, OrderBy: []*api.OrderBy{{Field: "id", Descending: true}}},
+			wantCount: 25,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for pages := 0; ; pages++ {
+				if pages > 25 {
+					t.Fatalf("pagination doesn't end")
+				}
+				stream := &mockListUsersServer{}
+				if err := s.ListUsers(tt.req, stream); err != nil {
+					t.Fatalf("unexpected error on call list users: %v", err)
+				}
+				for _, u := range stream.users {
+					if seen[u.Id] {
+						t.Errorf("user %s listed twice", u.Id)
+					}
+					seen[u.Id] = true
+				}
+				token := nextPageToken(stream)
+				if token == "" {
+					break
+				}
+				tt.req.PageToken = token
+			}
+			if len(seen) != tt.wantCount {
+				t.Errorf("listed %d users, want %d", len(seen), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestUserStore_ListUsers_PageToken_StableOnInsert(t *testing.T) {
+	s := makeStoreWithUsers(t, 6)
+
+	req := &api.ListUsersRequest{PageSize: 3, OrderBy: []*api.OrderBy{{Field: "email"}}}
+	first := &mockListUsersServer{}
+	if err := s.ListUsers(req, first); err != nil {
+		t.Fatalf("unexpected error on call list users: %v", err)
+	}
+
+	// a user sorted in the first page is added between the two calls.
+	makeUser(t, s, "a_first@example.com")
+
+	req.PageToken = nextPageToken(first)
+	second := &mockListUsersServer{}
+	if err := s.ListUsers(req, second); err != nil {
+		t.Fatalf("unexpected error on call list users: %v", err)
+	}
+	if len(second.users) != 3 || second.users[0].Email != "some_3@example.com" {
+		t.Errorf("second page starts with %v, want some_3@example.com", second.users)
+	}
+	if nextPageToken(second) != "" {
+		t.Errorf("unexpected next page token on the last page")
+	}
+}
+
+func TestUserStore_ListUsers_PageToken_Errors(t *testing.T) {
+	s := makeStoreWithUsers(t, 3)
+
+	stream := &mockListUsersServer{}
+	if err := s.ListUsers(&api.ListUsersRequest{PageSize: 1}, stream); err != nil {
+		t.Fatalf("unexpected error on call list users: %v", err)
+	}
+	token := nextPageToken(stream)
+	payload, signature, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name      string
+		req       *api.ListUsersRequest
+		wantError string
+	}{
+		{
+			name:      "tampered token",
+			req:       &api.ListUsersRequest{PageSize: 1, PageToken: payload + "x." + signature},
+			wantError: "invalid 'page_token'",
+		},
+		{
+			name:      "garbage token",
+			req:       &api.ListUsersRequest{PageSize: 1, PageToken: "garbage"},
+			wantError: "invalid 'page_token'",
+		},
+		{
+			name: "token of another query",
+			req: &api.ListUsersRequest{
+				PageSize: 1, PageToken: token, Filters: map[string]string{"country": "DE"},
+			},
+			wantError: "doesn't match",
+		},
+		{
+			name:      "token with page",
+			req:       &api.ListUsersRequest{PageSize: 1, PageToken: token, Page: 2},
+			wantError: "can't be combined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.ListUsers(tt.req, &mockListUsersServer{})
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestUserStore_ListUsersPage(t *testing.T) {
+	s := makeStoreWithUsers(t, 7)
+	makeUser(t, s, "other@example.org")
+
+	req := &api.ListUsersRequest{
+		PageSize: 3,
+		Where:    []*api.FieldFilter{{Field: "email", Operator: api.FieldFilter_CONTAINS, Values: []string{"example.com"}}},
+	}
+
+	var ids []string
+	wantPages := []struct {
+		page      int32
+		count     int
+		wantToken bool
+	}{
+		{page: 1, count: 3, wantToken: true},
+		{page: 0, count: 3, wantToken: true},
+		{page: 0, count: 1, wantToken: false},
+	}
+	for i, want := range wantPages {
+		reply, err := s.ListUsersPage(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error on call list users page: %v", err)
+		}
+		if reply.TotalCount != 7 {
+			t.Errorf("page %d: total count = %d, want 7", i, reply.TotalCount)
+		}
+		if reply.Page != want.page || reply.PageSize != 3 {
+			t.Errorf("page %d: page = %d, page size = %d", i, reply.Page, reply.PageSize)
+		}
+		if len(reply.Users) != want.count {
+			t.Errorf("page %d: %d users, want %d", i, len(reply.Users), want.count)
+		}
+		if (reply.NextPageToken != "") != want.wantToken {
+			t.Errorf("page %d: unexpected next page token %q", i, reply.NextPageToken)
+		}
+		for _, u := range reply.Users {
+			ids = append(ids, u.Id)
+		}
+		req.PageToken = reply.NextPageToken
+	}
+
+	// the streaming variant lists the same users.
+	stream := &mockListUsersServer{}
+	if err := s.ListUsers(&api.ListUsersRequest{PageSize: 10, Where: req.Where}, stream); err != nil {
+		t.Fatalf("unexpected error on call list users: %v", err)
+	}
+	for i, u := range stream.users {
+		if ids[i] != u.Id {
+			t.Errorf("user %d: ListUsersPage() id = %s, ListUsers() id = %s", i, ids[i], u.Id)
+		}
+	}
+}
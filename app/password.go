@@ -0,0 +1,264 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// plaintextPasswordBatchSize is the number of users loaded at once by HashPlaintextPasswords.
+const plaintextPasswordBatchSize = 100
+
+// ErrUnknownPasswordHash is returned when a stored password hash is not in a format known by the PasswordHasher.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordAlgorithm is a single password hashing scheme. The encoded hashes are self-describing (PHC string format for
+// argon2id, modular crypt format for bcrypt), so every algorithm can tell whether it owns a stored hash and whether that
+// hash was produced with its current parameters.
+type PasswordAlgorithm interface {
+	// Hash returns the encoded hash of password, including algorithm id, parameters and salt.
+	Hash(password string) (string, error)
+	// Owns reports whether encoded was produced by this algorithm.
+	Owns(encoded string) bool
+	// Verify checks password against encoded in constant time.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced with parameters different from the current ones.
+	NeedsRehash(encoded string) bool
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idAlgorithm implements PasswordAlgorithm with argon2id. Memory is expressed in KiB.
+type Argon2idAlgorithm struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+func NewArgon2idAlgorithm(time uint32, memory uint32, threads uint8) *Argon2idAlgorithm {
+	return &Argon2idAlgorithm{
+		Time:    time,
+		Memory:  memory,
+		Threads: threads,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (a *Argon2idAlgorithm) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generating salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2idAlgorithm) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a *Argon2idAlgorithm) Verify(password, encoded string) (bool, error) {
+	h, err := parseArgon2idHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (a *Argon2idAlgorithm) NeedsRehash(encoded string) bool {
+	h, err := parseArgon2idHash(encoded)
+	if err != nil {
+		return true
+	}
+
+	return h.version != argon2.Version || h.time != a.Time || h.memory != a.Memory || h.threads != a.Threads ||
+		uint32(len(h.key)) != a.KeyLen || uint32(len(h.salt)) != a.SaltLen
+}
+
+type argon2idHash struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2idHash decodes a hash in the form: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+func parseArgon2idHash(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	//nolint
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	h := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return nil, fmt.Errorf("parsing argon2id version: %w", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("parsing argon2id parameters: %w", err)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("decoding argon2id salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("decoding argon2id key: %w", err)
+	}
+
+	return h, nil
+}
+
+// BcryptAlgorithm implements PasswordAlgorithm with bcrypt. Note that bcrypt only uses the first 72 bytes of password.
+type BcryptAlgorithm struct {
+	Cost int
+}
+
+func NewBcryptAlgorithm(cost int) *BcryptAlgorithm {
+	return &BcryptAlgorithm{Cost: cost}
+}
+
+func (a *BcryptAlgorithm) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.Cost)
+	if err != nil {
+		return "", fmt.Errorf("generating bcrypt hash: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+func (a *BcryptAlgorithm) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (a *BcryptAlgorithm) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("comparing bcrypt hash: %w", err)
+	}
+
+	return true, nil
+}
+
+func (a *BcryptAlgorithm) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	return err != nil || cost != a.Cost
+}
+
+// PasswordHasher hashes new passwords with the current algorithm and verifies stored hashes produced by any of the
+// known algorithms. A stored hash produced by another algorithm, or by the current one with outdated parameters, is
+// reported as needing a rehash so callers can transparently upgrade it after a successful verification.
+type PasswordHasher struct {
+	current    PasswordAlgorithm
+	algorithms []PasswordAlgorithm
+}
+
+func NewPasswordHasher(current PasswordAlgorithm, legacy ...PasswordAlgorithm) *PasswordHasher {
+	return &PasswordHasher{
+		current:    current,
+		algorithms: append([]PasswordAlgorithm{current}, legacy...),
+	}
+}
+
+// DefaultPasswordHasher hashes with argon2id using the RFC 9106 second recommended parameters (t=3, m=64MiB, p=4) and
+// still accepts bcrypt hashes.
+func DefaultPasswordHasher() *PasswordHasher {
+	//nolint
+	return NewPasswordHasher(NewArgon2idAlgorithm(3, 64*1024, 4), NewBcryptAlgorithm(bcrypt.DefaultCost))
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify checks password against encoded. needsRehash is only meaningful when ok is true.
+func (h *PasswordHasher) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, algorithm := range h.algorithms {
+		if !algorithm.Owns(encoded) {
+			continue
+		}
+		ok, err = algorithm.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, algorithm != h.current || h.current.NeedsRehash(encoded), nil
+	}
+
+	return false, false, ErrUnknownPasswordHash
+}
+
+// IsHashed reports whether encoded is in a format known by the hasher.
+func (h *PasswordHasher) IsHashed(encoded string) bool {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Owns(encoded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashPassword hashes a password coming from the api. An empty password means that the user has no password set, and is
+// stored as is.
+func (h *PasswordHasher) hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	return h.Hash(password)
+}
+
+// HashPlaintextPasswords replaces every stored password that is not in a known hash format with its hash. It's meant to
+// be run on startup to migrate rows written before passwords were hashed, and returns the number of migrated users.
+// Only the passwords without the argon2id and bcrypt prefixes are loaded, by batches, so that it's cheap once migrated.
+func HashPlaintextPasswords(db *gorm.DB, hasher *PasswordHasher) (int, error) {
+	migrated := 0
+	var users []User
+	tx := db.Select("id", "password").
+		Where("password <> ? AND password NOT LIKE ? AND password NOT LIKE ?", "", argon2idPrefix+"%", "$2_$%").
+		FindInBatches(&users, plaintextPasswordBatchSize, func(_ *gorm.DB, _ int) error {
+			for _, u := range users {
+				if hasher.IsHashed(u.Password) {
+					continue
+				}
+				hash, err := hasher.Hash(u.Password)
+				if err != nil {
+					return err
+				}
+				err = db.Model(&User{}).Where("id = ? AND password = ?", u.ID, u.Password).
+					UpdateColumn("password", hash).Error
+				if err != nil {
+					return err
+				}
+				migrated++
+			}
+
+			return nil
+		})
+
+	return migrated, tx.Error
+}
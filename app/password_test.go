@@ -0,0 +1,185 @@
+package app_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters to keep the tests fast.
+func makeTestHasher() *app.PasswordHasher {
+	return app.NewPasswordHasher(app.NewArgon2idAlgorithm(1, 1024, 1), app.NewBcryptAlgorithm(bcrypt.MinCost))
+}
+
+func TestPasswordHasher_Verify(t *testing.T) {
+	argon2id := app.NewArgon2idAlgorithm(1, 1024, 1)
+	bcryptAlg := app.NewBcryptAlgorithm(bcrypt.MinCost)
+
+	tests := []struct {
+		name            string
+		hashWith        app.PasswordAlgorithm
+		verifyWith      *app.PasswordHasher
+		password        string
+		wantOk          bool
+		wantNeedsRehash bool
+	}{
+		{
+			name:       "argon2id_valid",
+			hashWith:   argon2id,
+			verifyWith: app.NewPasswordHasher(argon2id, bcryptAlg),
+			password:   "secret",
+			wantOk:     true,
+		},
+		{
+			name:       "bcrypt_valid",
+			hashWith:   bcryptAlg,
+			verifyWith: app.NewPasswordHasher(bcryptAlg, argon2id),
+			password:   "secret",
+			wantOk:     true,
+		},
+		{
+			name:            "argon2id_changed_parameters",
+			hashWith:        argon2id,
+			verifyWith:      app.NewPasswordHasher(app.NewArgon2idAlgorithm(2, 1024, 1)),
+			password:        "secret",
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "bcrypt_changed_cost",
+			hashWith:        bcryptAlg,
+			verifyWith:      app.NewPasswordHasher(app.NewBcryptAlgorithm(bcrypt.MinCost + 1)),
+			password:        "secret",
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "legacy_algorithm",
+			hashWith:        bcryptAlg,
+			verifyWith:      app.NewPasswordHasher(argon2id, bcryptAlg),
+			password:        "secret",
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:       "wrong_password",
+			hashWith:   argon2id,
+			verifyWith: app.NewPasswordHasher(argon2id),
+			password:   "wrong",
+			wantOk:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hashWith.Hash("secret")
+			if err != nil {
+				t.Fatalf("Hash() unexpected error: %v", err)
+			}
+			if strings.Contains(encoded, "secret") {
+				t.Errorf("Hash() leaked the password: %s", encoded)
+			}
+
+			ok, needsRehash, err := tt.verifyWith.Verify(tt.password, encoded)
+			if err != nil {
+				t.Fatalf("Verify() unexpected error: %v", err)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("Verify() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if needsRehash != tt.wantNeedsRehash {
+				t.Errorf("Verify() needsRehash = %v, want %v", needsRehash, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_Verify_UnknownFormat(t *testing.T) {
+	_, _, err := makeTestHasher().Verify("secret", "secret")
+	if !errors.Is(err, app.ErrUnknownPasswordHash) {
+		t.Errorf("Verify() error = %v, want %v", err, app.ErrUnknownPasswordHash)
+	}
+}
+
+func TestUserStore_PasswordsAreHashed(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	hasher := makeTestHasher()
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithPasswordHasher(hasher))
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Email:     "me@example.com",
+		Password:  "first_password",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	stored := app.User{}
+	db.First(&stored, "id = ?", added.Id)
+	if ok, _, err := hasher.Verify("first_password", stored.Password); err != nil || !ok {
+		t.Errorf("AddUser() stored password %q is not a hash of the given password", stored.Password)
+	}
+
+	newPassword := "second_password"
+	_, err = s.UpdateUser(context.Background(), &api.UpdateUserRequest{
+		Id:       added.Id,
+		Password: &newPassword,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+
+	db.First(&stored, "id = ?", added.Id)
+	if ok, _, err := hasher.Verify(newPassword, stored.Password); err != nil || !ok {
+		t.Errorf("UpdateUser() stored password %q is not a hash of the given password", stored.Password)
+	}
+}
+
+func TestHashPlaintextPasswords(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	hasher := makeTestHasher()
+
+	hashed, _ := hasher.Hash("already_hashed")
+	db.Create(&app.User{ID: "1", Password: "plaintext"})
+	db.Create(&app.User{ID: "2", Password: hashed})
+	db.Create(&app.User{ID: "3", Password: ""})
+	bcryptHash, _ := app.NewBcryptAlgorithm(4).Hash("bcrypt_hashed")
+	db.Create(&app.User{ID: "4", Password: bcryptHash})
+
+	migrated, err := app.HashPlaintextPasswords(db, hasher)
+	if err != nil {
+		t.Fatalf("HashPlaintextPasswords() unexpected error: %v", err)
+	}
+	if migrated != 1 {
+		t.Errorf("HashPlaintextPasswords() migrated = %d, want 1", migrated)
+	}
+
+	var users []app.User
+	db.Order("id").Find(&users)
+	if ok, _, _ := hasher.Verify("plaintext", users[0].Password); !ok {
+		t.Errorf("plaintext password was not hashed: %q", users[0].Password)
+	}
+	if users[1].Password != hashed {
+		t.Errorf("already hashed password was changed: %q", users[1].Password)
+	}
+	if users[2].Password != "" {
+		t.Errorf("empty password was changed: %q", users[2].Password)
+	}
+	if users[3].Password != bcryptHash {
+		t.Errorf("bcrypt hashed password was changed: %q", users[3].Password)
+	}
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a very simple in-memory fixed window rate limiter keyed by an arbitrary string. Being in-memory, the
+// limits are per server instance.
+type rateLimiter struct {
+	lock    *sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+	now     func() time.Time
+	// Expired windows are swept at most once per window, so the map holds the keys seen in the last two windows.
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	hits  int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		lock:    &sync.Mutex{},
+		limit:   limit,
+		window:  window,
+		windows: map[string]*rateWindow{},
+		now:     time.Now,
+	}
+}
+
+// Allow registers a hit for key and reports whether it is within the limit. When it's not, retryAfter tells when the
+// current window ends. A non-positive limit disables rate limiting.
+func (l *rateLimiter) Allow(key string) (allowed bool, retryAfter time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		if now.Sub(l.lastSweep) >= l.window {
+			l.sweep(now)
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+
+	if w.hits >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.hits++
+
+	return true, 0
+}
+
+// sweep drops expired windows so the map doesn't grow with every key ever seen. It walks the whole map, so it's only
+// called once per window: sweeping for every new key would make spraying distinct keys quadratic.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// readableUserFields maps the api.User fields, as read_mask paths, to the column they are read from.
+var readableUserFields = map[string]string{
+	"id":         "id",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"nickname":   "nickname",
+	"email":      "email",
+	"country":    "country",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"deleted_at": "deleted_at",
+	"version":    "version",
+}
+
+// readMaskColumns returns the columns to select for the fields of mask, plus the extra columns the query itself needs,
+// or nil to select all the columns when there is no mask.
+func readMaskColumns(mask *fieldmaskpb.FieldMask, extra ...string) ([]string, error) {
+	if len(mask.GetPaths()) == 0 {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	columns := make([]string, 0, len(mask.Paths)+len(extra))
+	add := func(column string) {
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	for _, path := range mask.Paths {
+		column, ok := readableUserFields[path]
+		if !ok {
+			return nil, invalidArgumentError("read_mask", fmt.Sprintf(
+				"unknown field %q, valid fields are: %s", path, strings.Join(validReadMaskPaths(), ", "),
+			))
+		}
+		add(column)
+	}
+	for _, column := range extra {
+		add(column)
+	}
+
+	return columns, nil
+}
+
+// applyReadMask clears the fields of u that aren't part of mask, if any.
+func applyReadMask(u *api.User, mask *fieldmaskpb.FieldMask) *api.User {
+	if len(mask.GetPaths()) == 0 {
+		return u
+	}
+
+	paths := make(map[protoreflect.Name]bool, len(mask.Paths))
+	for _, path := range mask.Paths {
+		paths[protoreflect.Name(path)] = true
+	}
+	m := u.ProtoReflect()
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if !paths[fd.Name()] {
+			m.Clear(fd)
+		}
+
+		return true
+	})
+
+	return u
+}
+
+func validReadMaskPaths() []string {
+	paths := make([]string, 0, len(readableUserFields))
+	for path := range readableUserFields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
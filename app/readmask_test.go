@@ -0,0 +1,77 @@
+package app_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestUserStore_ReadMask(t *testing.T) {
+	s := makeStoreWithUsers(t, 5)
+	ctx := context.Background()
+	mask := &fieldmaskpb.FieldMask{Paths: []string{"id", "email"}}
+
+	// paging through the users, sorted on a column outside of the mask.
+	var listed []*api.User
+	req := &api.ListUsersRequest{
+		PageSize: 2,
+		OrderBy:  []*api.OrderBy{{Field: "nickname", Descending: true}},
+		ReadMask: mask,
+	}
+	for {
+		stream := &mockListUsersServer{}
+		if err := s.ListUsers(req, stream); err != nil {
+			t.Fatalf("unexpected error on call list users: %v", err)
+		}
+		listed = append(listed, stream.users...)
+		if req.PageToken = nextPageToken(stream); req.PageToken == "" {
+			break
+		}
+	}
+	if len(listed) != 5 || listed[0].Email != "some_4@example.com" {
+		t.Fatalf("ListUsers() with read mask listed: %v", listed)
+	}
+	for _, u := range listed {
+		if !proto.Equal(u, &api.User{Id: u.Id, Email: u.Email}) || u.Id == "" {
+			t.Errorf("ListUsers() sent unmasked fields: %v", u)
+		}
+	}
+
+	page, err := s.ListUsersPage(ctx, &api.ListUsersRequest{ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}}})
+	if err != nil {
+		t.Fatalf("unexpected error on call list users page: %v", err)
+	}
+	if page.TotalCount != 5 || !proto.Equal(page.Users[0], &api.User{Country: "DE"}) {
+		t.Errorf("ListUsersPage() with read mask: %v", page)
+	}
+
+	reply, err := s.GetUser(ctx, &api.GetUserRequest{
+		Selector: &api.GetUserRequest_Email{Email: "some_1@example.com"},
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "version"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call get user: %v", err)
+	}
+	if !proto.Equal(reply.User, &api.User{Nickname: "nick_1", Version: 1}) {
+		t.Errorf("GetUser() with read mask: %v", reply.User)
+	}
+
+	_, err = s.GetUser(ctx, &api.GetUserRequest{
+		Selector: &api.GetUserRequest_Email{Email: "some_1@example.com"},
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "unknown field \"password\"") {
+		t.Errorf("GetUser() with invalid read mask: want InvalidArgument, got: %v", err)
+	}
+	err = s.ListUsers(&api.ListUsersRequest{ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"failed_logins"}}},
+		&mockListUsersServer{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListUsers() with invalid read mask: want InvalidArgument, got: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDHeader is the request and response metadata key carrying the request id.
+	RequestIDHeader = "x-request-id"
+
+	maxRequestIDLength = 128
+)
+
+type requestIDKey struct{}
+
+// RequestID returns the id of the request ctx belongs to, or "" if it has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// withRequestID returns ctx carrying the id sent by the client in the request metadata, or a new one if it sent none
+// or an invalid one.
+func withRequestID(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDHeader); len(values) != 0 && validMetadataValue(values[0], maxRequestIDLength) {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// validMetadataValue accepts client supplied values like request ids made of up to maxLength printable ascii
+// characters, so that they can safely be logged and echoed back.
+func validMetadataValue(value string, maxLength int) bool {
+	if value == "" || len(value) > maxLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < ' ' || value[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withRequestInfo attaches the request id to the details of err, unless it already carries one.
+func withRequestInfo(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+
+	st := status.Convert(err)
+	for _, d := range st.Details() {
+		if _, ok := d.(*errdetails.RequestInfo); ok {
+			return err
+		}
+	}
+	withInfo, detailsErr := st.WithDetails(&errdetails.RequestInfo{RequestId: id})
+	if detailsErr != nil {
+		return err
+	}
+
+	return withInfo.Err()
+}
+
+// UnaryRequestIDInterceptor assigns an id to every unary request, echoes it in the response header metadata and
+// attaches it to the returned errors.
+func UnaryRequestIDInterceptor(
+	ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	ctx, id := withRequestID(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+	resp, err := handler(ctx, req)
+
+	return resp, withRequestInfo(err, id)
+}
+
+// StreamRequestIDInterceptor is the streaming counterpart of UnaryRequestIDInterceptor.
+func StreamRequestIDInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id := withRequestID(ss.Context())
+	_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+
+	return withRequestInfo(handler(srv, &requestIDServerStream{ServerStream: ss, ctx: ctx}), id)
+}
+
+// requestIDServerStream overrides the context of a stream with one carrying the request id.
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDServerStream) Context() context.Context {
+	return s.ctx
+}
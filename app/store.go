@@ -2,45 +2,122 @@ package app
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/sir-hassan/grpc-service-user/api"
-	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type User struct {
-	ID        string
-	FirstName string
-	LastName  string
-
-	Nickname string
-	Password string
-	Email    string
-	Country  string
-
-	CreatedAt time.Time
-	UpdatedAt time.Time
-}
-
 type UserStore struct {
 	api.UserStoreServer
 	db       *gorm.DB
 	lg       zerolog.Logger
 	notifier Notifier
+	hasher   *PasswordHasher
+
+	authPolicy    AuthPolicy
+	authLimiter   *rateLimiter
+	dummyHashOnce *sync.Once
+	dummyHash     string
+
+	pageTokens *pageTokenCodec
+
+	idempotencyKeyTTL time.Duration
+	idempotencySecret []byte
+
+	// Whether notifications are written to the outbox, see WithOutbox.
+	outbox bool
+}
+
+// UserStoreOption configures optional UserStore behaviour.
+type UserStoreOption func(s *UserStore)
+
+// WithPasswordHasher sets the hasher used for user passwords, DefaultPasswordHasher() is used otherwise.
+func WithPasswordHasher(hasher *PasswordHasher) UserStoreOption {
+	return func(s *UserStore) {
+		s.hasher = hasher
+	}
 }
 
 var _ api.UserStoreServer = &UserStore{}
 
 func (s *UserStore) UpdateUser(ctx context.Context, req *api.UpdateUserRequest) (*api.UpdateUserReply, error) {
+	reply := &api.UpdateUserReply{}
+	call, replayed, err := s.idempotentCall(ctx, "UpdateUser", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
+
+	patches, err := s.userPatches(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedUser *User
+	replayed, err = call.transaction(ctx, "update transaction in UpdateUser func", reply, func(tx *gorm.DB) error {
+		updatedUser, err = s.patchUser(ctx, tx, req.Id, req.ExpectedVersion, patches)
+		if err != nil {
+			return err
+		}
+		reply.User = toAPIUser(updatedUser)
+
+		return s.enqueueNotifications(ctx, tx, UpdateNotification, updatedUser)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !replayed {
+		s.notifyCommitted(UpdateNotification, updatedUser)
+	}
+
+	return reply, nil
+}
+
+// userPatches validates an update request, and returns the columns to update. Passwords get hashed here, so that
+// it's done before the update transaction starts.
+func (s *UserStore) userPatches(ctx context.Context, req *api.UpdateUserRequest) (map[string]any, error) {
 	patches := map[string]any{}
 
+	if err := applyUpdateMask(req); err != nil {
+		return nil, err
+	}
 	if req.Id == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing or empty 'id' field")
+		return nil, invalidArgumentError("id", "missing or empty 'id' field")
+	}
+
+	v := &userValidator{}
+	if req.FirstName != nil {
+		v.name("first_name", req.FirstName)
+	}
+	if req.LastName != nil {
+		v.name("last_name", req.LastName)
+	}
+	if req.Nickname != nil {
+		v.nickname(req.Nickname)
+	}
+	if req.Password != nil {
+		v.password(*req.Password)
+	}
+	if req.Email != nil {
+		v.email(req.Email)
+	}
+	if req.Country != nil {
+		v.country(req.Country)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
 	if req.FirstName != nil {
@@ -53,7 +130,11 @@ func (s *UserStore) UpdateUser(ctx context.Context, req *api.UpdateUserRequest)
 		patches["nickname"] = *req.Nickname
 	}
 	if req.Password != nil {
-		patches["password"] = *req.Password
+		hash, err := s.hasher.hashPassword(*req.Password)
+		if err != nil {
+			return nil, s.internalError(ctx, err, "hashing password in UpdateUser func")
+		}
+		patches["password"] = hash
 	}
 	if req.Email != nil {
 		patches["email"] = *req.Email
@@ -61,76 +142,149 @@ func (s *UserStore) UpdateUser(ctx context.Context, req *api.UpdateUserRequest)
 	if req.Country != nil {
 		patches["country"] = *req.Country
 	}
+	if len(patches) == 0 {
+		// an empty update would still bump the version and notify the webhooks of a change that didn't happen.
+		field := "user"
+		if req.UpdateMask != nil {
+			field = "update_mask"
+		}
 
-	tx := s.db.Begin()
-	tx.Where("id = ?", req.Id).Model(&User{}).Updates(patches)
-	if tx.Error != nil {
-		tx.Rollback()
-		s.lg.Err(tx.Error).Msg("update query in UpdateUser func")
-
-		return nil, status.Error(codes.Internal, "internal server error")
+		return nil, invalidArgumentError(field, "no field to update")
 	}
 
-	updatedUser := User{}
-	tx.First(&updatedUser, "id = ?", req.Id)
-	if tx.Error != nil {
-		tx.Rollback()
-		s.lg.Err(tx.Error).Msg("select query in UpdateUser func")
+	patches["version"] = gorm.Expr("version + 1")
 
-		return nil, status.Error(codes.Internal, "internal server error")
+	return patches, nil
+}
+
+// patchUser updates the user having id within tx, and returns the updated user.
+func (s *UserStore) patchUser(
+	ctx context.Context, tx *gorm.DB, id string, expectedVersion int64, patches map[string]any,
+) (*User, error) {
+	query := tx.Where("id = ?", id)
+	if expectedVersion != 0 {
+		// checking the version in the update itself prevents concurrent updates from both succeeding.
+		query = query.Where("version = ?", expectedVersion)
+	}
+	res := query.Model(&User{}).Updates(patches)
+	if err := res.Error; err != nil {
+		if field, ok := uniqueViolationField(err); ok {
+			return nil, alreadyExistsError(field)
+		}
+		return nil, s.internalError(ctx, err, "update query in UpdateUser func")
+	}
+
+	updatedUser := &User{}
+	if err := tx.Where("id = ?", id).Limit(1).Find(updatedUser).Error; err != nil {
+		return nil, s.internalError(ctx, err, "select query in UpdateUser func")
 	}
 	if updatedUser.ID == "" {
-		tx.Rollback()
+		return nil, notFoundError("id", id)
+	}
+	if res.RowsAffected == 0 {
+		return nil, versionMismatchError(expectedVersion, updatedUser.Version)
+	}
+
+	return updatedUser, nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, req *api.DeleteUserRequest) (*api.DeleteUserReply, error) {
+	reply := &api.DeleteUserReply{}
+	call, replayed, err := s.idempotentCall(ctx, "DeleteUser", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
 
-		return nil, status.Error(codes.NotFound, "id not found")
+	var deletedUser *User
+	replayed, err = call.transaction(ctx, "delete transaction in DeleteUser func", reply, func(tx *gorm.DB) error {
+		deletedUser, err = s.deleteUser(ctx, tx, req)
+		if err != nil {
+			return err
+		}
+
+		return s.enqueueNotifications(ctx, tx, DeleteNotification, deletedUser)
+	})
+	if err != nil {
+		return nil, err
 	}
-	tx.Commit()
 
-	s.notifier.Notify(&updatedUser, UpdateNotification)
+	if !replayed {
+		s.notifyCommitted(DeleteNotification, deletedUser)
+	}
 
-	return &api.UpdateUserReply{}, nil
+	return reply, nil
 }
 
-func (s *UserStore) DeleteUser(ctx context.Context, req *api.DeleteUserRequest) (*api.DeleteUserReply, error) {
+// deleteUser soft deletes a user within tx, and returns it.
+func (s *UserStore) deleteUser(ctx context.Context, tx *gorm.DB, req *api.DeleteUserRequest) (*User, error) {
 	if req.Id == "" {
-		return nil, status.Error(codes.InvalidArgument, "missing or empty 'id' field")
+		return nil, invalidArgumentError("id", "missing or empty 'id' field")
 	}
 
-	userToDelete := User{}
-	tx := s.db.Begin()
-	tx.First(&userToDelete, "id = ?", req.Id)
-	if tx.Error != nil {
-		tx.Rollback()
-		s.lg.Err(tx.Error).Msg("select query in DeleteUser func")
-
-		return nil, status.Error(codes.Internal, "internal server error")
+	userToDelete := &User{}
+	if err := tx.Where("id = ?", req.Id).Limit(1).Find(userToDelete).Error; err != nil {
+		return nil, s.internalError(ctx, err, "select query in DeleteUser func")
 	}
 	if userToDelete.ID == "" {
-		tx.Rollback()
-
-		return nil, status.Error(codes.NotFound, "id not found")
+		return nil, notFoundError("id", req.Id)
 	}
 
-	tx.Where("id = ?", req.Id).Delete(&User{})
-	if tx.Error != nil {
-		tx.Rollback()
-		s.lg.Err(tx.Error).Msg("delete query in DeleteUser func")
+	query := tx.Where("id = ?", req.Id)
+	if req.ExpectedVersion != 0 {
+		query = query.Where("version = ?", req.ExpectedVersion)
+	}
+	// User has a DeletedAt field, so this only sets it.
+	res := query.Delete(&User{})
+	if res.Error != nil {
+		return nil, s.internalError(ctx, res.Error, "delete query in DeleteUser func")
+	}
+	if res.RowsAffected == 0 {
+		if req.ExpectedVersion == 0 {
+			// deleted concurrently.
+			return nil, notFoundError("id", req.Id)
+		}
 
-		return nil, status.Error(codes.Internal, "internal server error")
+		return nil, versionMismatchError(req.ExpectedVersion, userToDelete.Version)
 	}
-	tx.Commit()
 
-	s.notifier.Notify(&userToDelete, DeleteNotification)
+	return userToDelete, nil
+}
+
+// transaction runs fn in a transaction, which is rolled back if fn returns an error. fn returns status errors, any
+// other error comes from the transaction itself and is reported as an internal error.
+func (s *UserStore) transaction(ctx context.Context, msg string, fn func(tx *gorm.DB) error) error {
+	err := s.db.WithContext(ctx).Transaction(fn)
+	if _, ok := status.FromError(err); !ok {
+		return s.internalError(ctx, err, msg)
+	}
 
-	return &api.DeleteUserReply{}, nil
+	return err
 }
 
-func NewUserStore(db *gorm.DB, notifier Notifier, lg zerolog.Logger) *UserStore {
-	return &UserStore{
+func NewUserStore(db *gorm.DB, notifier Notifier, lg zerolog.Logger, opts ...UserStoreOption) *UserStore {
+	s := &UserStore{
 		db:       db,
 		lg:       lg,
 		notifier: notifier,
+		hasher:   DefaultPasswordHasher(),
+
+		authPolicy:    DefaultAuthPolicy(),
+		dummyHashOnce: &sync.Once{},
+
+		pageTokens: newPageTokenCodec(randomKey()),
+
+		idempotencyKeyTTL: DefaultIdempotencyKeyTTL,
+		idempotencySecret: randomKey(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.authLimiter = newRateLimiter(s.authPolicy.RateLimit, s.authPolicy.RateLimitWindow)
+
+	return s
 }
 
 func (s *UserStore) CheckHealth(ctx context.Context, req *api.CheckHealthRequest) (*api.CheckHealthReply, error) {
@@ -154,98 +308,293 @@ func (s *UserStore) CheckHealth(ctx context.Context, req *api.CheckHealthRequest
 }
 
 func (s *UserStore) AddUser(ctx context.Context, req *api.AddUserRequest) (*api.AddUserReply, error) {
-	id := uuid.New().String()
+	reply := &api.AddUserReply{}
+	call, replayed, err := s.idempotentCall(ctx, "AddUser", req, reply)
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return reply, nil
+	}
 
-	// check for missing required fields
-	if req.FirstName == "" {
-		return nil, status.Error(codes.InvalidArgument, "empty or missing 'first_name' field")
+	newUser, err := s.newUser(ctx, req)
+	if err != nil {
+		return nil, err
 	}
-	if req.LastName == "" {
-		return nil, status.Error(codes.InvalidArgument, "empty or missing 'last_name' field")
+	replayed, err = call.transaction(ctx, "insert transaction in AddUser func", reply, func(tx *gorm.DB) error {
+		if err := s.insertUser(ctx, tx, newUser); err != nil {
+			return err
+		}
+		reply.Id, reply.User = newUser.ID, toAPIUser(newUser)
+
+		return s.enqueueNotifications(ctx, tx, AddNotification, newUser)
+	})
+	if err != nil {
+		return nil, err
 	}
-	if req.Email == "" {
-		return nil, status.Error(codes.InvalidArgument, "empty or missing 'email' field")
+
+	if !replayed {
+		s.notifyCommitted(AddNotification, newUser)
 	}
 
-	newUser := &User{
-		ID:        id,
+	return reply, nil
+}
+
+// newUser validates an add request, and returns the user to insert. Its password gets hashed here, so that it's done
+// before any insert transaction starts.
+func (s *UserStore) newUser(ctx context.Context, req *api.AddUserRequest) (*User, error) {
+	v := &userValidator{}
+	v.name("first_name", &req.FirstName)
+	v.name("last_name", &req.LastName)
+	v.nickname(&req.Nickname)
+	v.password(req.Password)
+	v.email(&req.Email)
+	v.country(&req.Country)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := s.hasher.hashPassword(req.Password)
+	if err != nil {
+		return nil, s.internalError(ctx, err, "hashing password in AddUser func")
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+
+	return &User{
+		ID:        uuid.New().String(),
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Nickname:  req.Nickname,
-		Password:  req.Password,
+		Password:  passwordHash,
 		Email:     req.Email,
 		Country:   req.Country,
+		Version:   1,
+		// postgres timestamps have a microsecond precision, truncating makes the returned user match the stored one.
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// insertUser inserts u within tx.
+func (s *UserStore) insertUser(ctx context.Context, tx *gorm.DB, u *User) error {
+	if err := tx.Create(u).Error; err != nil {
+		if field, ok := uniqueViolationField(err); ok {
+			return alreadyExistsError(field)
+		}
+		return s.internalError(ctx, err, "insert query in AddUser func")
+	}
+
+	return nil
+}
+
+func (s *UserStore) GetUser(ctx context.Context, req *api.GetUserRequest) (*api.GetUserReply, error) {
+	var column, value string
+	switch sel := req.Selector.(type) {
+	case *api.GetUserRequest_Id:
+		column, value = "id", sel.Id
+	case *api.GetUserRequest_Email:
+		column, value = "email", sel.Email
+	case *api.GetUserRequest_Nickname:
+		column, value = "nickname", sel.Nickname
+	}
+	if value == "" {
+		return nil, invalidArgumentError("selector", "missing or empty 'id', 'email' or 'nickname' field")
 	}
 
-	if tx := s.db.Create(newUser); tx.Error != nil {
-		s.lg.Err(tx.Error).Msg("insert query in AddUser func")
+	columns, err := readMaskColumns(req.ReadMask)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil, status.Error(codes.Internal, "internal server error")
+	user, err := s.findUser(ctx, column, value, columns...)
+	if errors.Is(err, errAmbiguousNickname) {
+		return nil, ambiguousNicknameError()
+	}
+	if err != nil {
+		return nil, s.internalError(ctx, err, "select query in GetUser func")
+	}
+	if user == nil {
+		return nil, notFoundError(column, value)
 	}
 
-	s.notifier.Notify(newUser, AddNotification)
+	return &api.GetUserReply{User: applyReadMask(toAPIUser(user), req.ReadMask)}, nil
+}
 
-	return &api.AddUserReply{Id: id}, nil
+// errAmbiguousNickname is returned by findUser when several users share the looked up nickname.
+var errAmbiguousNickname = errors.New("nickname shared by several users")
+
+// findUser returns the user having value in one of the lookup columns (id, email or nickname), or nil if there is
+// none. Emails are matched case-insensitively. Only the given columns are selected, if any. As nicknames aren't unique
+// when the unique index is disabled, errAmbiguousNickname is returned when several users share the nickname, instead of
+// picking one of them.
+func (s *UserStore) findUser(ctx context.Context, column string, value string, columns ...string) (*User, error) {
+	//nolint
+	query := s.db.WithContext(ctx).Limit(2)
+	if len(columns) != 0 {
+		query = query.Select(columns)
+	}
+	switch column {
+	case "id":
+		query = query.Where("id = ?", value)
+	case "email":
+		query = query.Where("LOWER(email) = LOWER(?)", value)
+	case "nickname":
+		query = query.Where("nickname = ?", value)
+	default:
+		s.lg.Fatal().Str("column", column).Msg("logic error, unexpected column value")
+	}
+
+	var users []User
+	if tx := query.Find(&users); tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(users) == 0 {
+		//nolint
+		return nil, nil
+	}
+	if len(users) > 1 {
+		return nil, errAmbiguousNickname
+	}
+
+	return &users[0], nil
 }
 
-func paginateAndFilter(page int, pageSize int, filters map[string]string) func(db *gorm.DB) *gorm.DB {
-	return func(db *gorm.DB) *gorm.DB {
-		// normalize input
-		if page < 1 {
-			// default page
-			page = 1
+func (s *UserStore) ListUsers(req *api.ListUsersRequest, lus api.UserStore_ListUsersServer) error {
+	page, err := s.listUsers(lus.Context(), req, false)
+	if err != nil {
+		return err
+	}
+
+	if page.nextPageToken != "" {
+		if err = lus.SetHeader(metadata.Pairs(nextPageTokenHeader, page.nextPageToken)); err != nil {
+			return s.internalError(lus.Context(), err, "rpc set header in ListUsers func")
 		}
-		if pageSize < 1 {
-			// default page size
-			pageSize = 10
+	}
+
+	for i := range page.users {
+		err = lus.Send(applyReadMask(toAPIUser(&page.users[i]), req.ReadMask))
+		if err != nil {
+			return s.internalError(lus.Context(), err, "rpc send in ListUsers func")
 		}
-		offset := (page - 1) * pageSize
+	}
 
-		if len(filters) == 0 {
-			return db.Offset(offset).Limit(pageSize)
+	return nil
+}
+
+// userPage is a page of users with its pagination metadata.
+type userPage struct {
+	users         []User
+	totalCount    int64
+	page          int
+	pageSize      int
+	nextPageToken string
+}
+
+// listUsers returns a page of users and the token of the next page, if any. Pages are selected either by number
+// (offset) or by page token (keyset). The total count of matching users is only computed on demand, in the same
+// transaction as the page query.
+func (s *UserStore) listUsers(ctx context.Context, req *api.ListUsersRequest, withCount bool) (*userPage, error) {
+	conds, err := filterConditions(req.Filters, req.Where)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sortKeys(req.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	// the sort columns are always selected, as the next page token is made of their values.
+	sortColumns := make([]string, len(keys))
+	for i, k := range keys {
+		sortColumns[i] = k.column
+	}
+	columns, err := readMaskColumns(req.ReadMask, sortColumns...)
+	if err != nil {
+		return nil, err
+	}
+	page, pageSize := normalizePage(req.Page, req.PageSize)
+	queryHash := listQueryHash(req)
+
+	pageConds := conds
+	if req.PageToken != "" {
+		if req.Page > 1 {
+			return nil, invalidArgumentError("page_token", "'page' and 'page_token' fields can't be combined")
+		}
+		token, err := s.pageTokens.decode(req.PageToken)
+		if err != nil {
+			return nil, invalidArgumentError("page_token", "invalid 'page_token' field")
+		}
+		if token.Query != queryHash {
+			return nil, invalidArgumentError("page_token", "'page_token' doesn't match the request filters and order")
 		}
+		cond, err := keysetCondition(keys, token.Values)
+		if err != nil {
+			return nil, invalidArgumentError("page_token", "invalid 'page_token' field")
+		}
+		pageConds = append(append([]clause.Expression{}, conds...), cond)
+		page = 0
+	}
 
-		query := ""
-		var values []any
+	result := &userPage{page: page, pageSize: pageSize}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if req.IncludeDeleted {
+			tx = tx.Unscoped()
+		}
+		if withCount {
+			countQuery := tx.Model(&User{})
+			if len(conds) != 0 {
+				countQuery = countQuery.Clauses(clause.Where{Exprs: conds})
+			}
+			if err := countQuery.Count(&result.totalCount).Error; err != nil {
+				return err
+			}
+		}
 
-		for filterName, filterVal := range filters {
-			query += "AND " + filterName + " = ?"
-			values = append(values, filterVal)
+		// one more user is selected to find out whether there is a next page.
+		query := tx.Clauses(orderClause(keys)).Limit(pageSize + 1)
+		if columns != nil {
+			query = query.Select(columns)
+		}
+		if page > 1 {
+			query = query.Offset((page - 1) * pageSize)
+		}
+		if len(pageConds) != 0 {
+			query = query.Clauses(clause.Where{Exprs: pageConds})
 		}
-		query = query[3:]
 
-		return db.Offset(offset).Where(query, values...).Limit(pageSize)
+		return query.Find(&result.users).Error
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, s.internalError(ctx, err, "select query in ListUsers func")
 	}
-}
 
-func (s *UserStore) ListUsers(req *api.ListUsersRequest, lus api.UserStore_ListUsersServer) error {
-	var users []User
-	tx := s.db.Scopes(paginateAndFilter(int(req.Page), int(req.PageSize), req.Filters)).Find(&users)
-	if tx.Error != nil {
-		s.lg.Err(tx.Error).Msg("select query in ListUsers func")
-
-		return status.Error(codes.Internal, "internal server error")
-	}
-
-	var err error
-	for _, u := range users {
-		err = lus.Send(&api.User{
-			Id:        u.ID,
-			FirstName: u.FirstName,
-			LastName:  u.LastName,
-			Nickname:  u.Nickname,
-			Password:  u.Password,
-			Email:     u.Email,
-			Country:   u.Country,
-			CreatedAt: timestamppb.New(u.CreatedAt),
-			UpdatedAt: timestamppb.New(u.UpdatedAt),
+	if len(result.users) > pageSize {
+		result.users = result.users[:pageSize]
+		result.nextPageToken = s.pageTokens.encode(pageToken{
+			Query:  queryHash,
+			Values: tokenValues(keys, &result.users[pageSize-1]),
 		})
-		if err != nil {
-			s.lg.Err(err).Msg("rpc send in ListUsers func")
+	}
 
-			return status.Error(codes.Internal, "internal server error")
-		}
+	return result, nil
+}
+
+func (s *UserStore) ListUsersPage(ctx context.Context, req *api.ListUsersRequest) (*api.ListUsersPageReply, error) {
+	page, err := s.listUsers(ctx, req, true)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	reply := &api.ListUsersPageReply{
+		Users:         make([]*api.User, len(page.users)),
+		TotalCount:    page.totalCount,
+		Page:          int32(page.page),
+		PageSize:      int32(page.pageSize),
+		NextPageToken: page.nextPageToken,
+	}
+	for i := range page.users {
+		reply.Users[i] = applyReadMask(toAPIUser(&page.users[i]), req.ReadMask)
+	}
+
+	return reply, nil
 }
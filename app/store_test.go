@@ -11,6 +11,10 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sir-hassan/grpc-service-user/api"
 	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
 )
 
@@ -19,14 +23,36 @@ func makeMockDB() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(app.User{})
+	err = app.Migrate(db, true)
 	if err != nil {
 		return nil, err
 	}
 
+	// every connection to an in-memory database gets its own database, so a single one must be used.
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
 	return db, nil
 }
 
+func makeUser(t testing.TB, s *app.UserStore, email string) string {
+	t.Helper()
+
+	reply, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "first_name",
+		LastName:  "last_name",
+		Email:     email,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	return reply.Id
+}
+
 func TestUserStore_AddUser_ValidCases(t *testing.T) {
 	db, err := makeMockDB()
 	if err != nil {
@@ -117,6 +143,54 @@ func TestUserStore_UpdateUser_ValidCase(t *testing.T) {
 	}
 }
 
+func TestUserStore_ReturnedUser(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	ctx := context.Background()
+	stored := func(id string) *api.User {
+		reply, err := s.GetUser(ctx, &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: id}})
+		if err != nil {
+			t.Fatalf("unexpected error on call get user: %v", err)
+		}
+
+		return reply.User
+	}
+
+	added, err := s.AddUser(ctx, &api.AddUserRequest{
+		FirstName: " fn ",
+		LastName:  "ln",
+		Email:     "me@example.com",
+		Password:  "secret",
+		Country:   "de",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+	u := added.User
+	if u.Id != added.Id || u.FirstName != "fn" || u.Country != "DE" || u.Version != 1 || u.CreatedAt == nil {
+		t.Errorf("AddUser() returned user: %v", u)
+	}
+	if !proto.Equal(u, stored(added.Id)) {
+		t.Errorf("AddUser() returned user %v, stored %v", u, stored(added.Id))
+	}
+
+	lastName := "updated"
+	updated, err := s.UpdateUser(ctx, &api.UpdateUserRequest{Id: added.Id, LastName: &lastName})
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	u = updated.User
+	if u.LastName != "updated" || u.FirstName != "fn" || u.Version != 2 {
+		t.Errorf("UpdateUser() returned user: %v", u)
+	}
+	if !proto.Equal(u, stored(added.Id)) {
+		t.Errorf("UpdateUser() returned user %v, stored %v", u, stored(added.Id))
+	}
+}
+
 func TestUserStore_DeleteUser_ValidCase(t *testing.T) {
 	db, err := makeMockDB()
 	if err != nil {
@@ -256,3 +330,221 @@ func TestUserStore_UpdateUser_ErrorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestUserStore_GetUser(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Errorf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Nickname:  "nick1",
+		Email:     "me@example.com",
+	})
+	if err != nil {
+		t.Errorf("unexpected error on call add user: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		req       *api.GetUserRequest
+		wantError string
+	}{
+		{
+			name: "by id",
+			req:  &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: added.Id}},
+		},
+		{
+			name: "by email",
+			req:  &api.GetUserRequest{Selector: &api.GetUserRequest_Email{Email: "ME@example.com"}},
+		},
+		{
+			name: "by nickname",
+			req:  &api.GetUserRequest{Selector: &api.GetUserRequest_Nickname{Nickname: "nick1"}},
+		},
+		{
+			name:      "unknown id",
+			req:       &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: "some_uuid"}},
+			wantError: "code = NotFound",
+		},
+		{
+			name:      "unknown nickname",
+			req:       &api.GetUserRequest{Selector: &api.GetUserRequest_Nickname{Nickname: "nick2"}},
+			wantError: "code = NotFound",
+		},
+		{
+			name:      "empty selector",
+			req:       &api.GetUserRequest{},
+			wantError: "code = InvalidArgument",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := s.GetUser(context.Background(), tt.req)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("unexpected error, want: %s, got: %v", tt.wantError, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error on call get user: %v", err)
+
+				return
+			}
+			if reply.User.Id != added.Id || reply.User.Email != "me@example.com" || reply.User.Nickname != "nick1" {
+				t.Errorf("GetUser() returned unexpected user: %v", reply.User)
+			}
+		})
+	}
+}
+
+func conflictingField(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Metadata["field"]
+		}
+	}
+
+	return ""
+}
+
+func TestUserStore_UniqueFields(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	_, err = s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1", LastName: "ln1", Email: "me@example.com", Nickname: "nick1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+	other, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn2", LastName: "ln2", Email: "other@example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		call      func() error
+		wantField string
+	}{
+		{
+			name: "add with same email in another case",
+			call: func() error {
+				_, err := s.AddUser(context.Background(), &api.AddUserRequest{
+					FirstName: "fn3", LastName: "ln3", Email: "ME@Example.com",
+				})
+
+				return err
+			},
+			wantField: "email",
+		},
+		{
+			name: "add with same nickname",
+			call: func() error {
+				_, err := s.AddUser(context.Background(), &api.AddUserRequest{
+					FirstName: "fn3", LastName: "ln3", Email: "third@example.com", Nickname: "nick1",
+				})
+
+				return err
+			},
+			wantField: "nickname",
+		},
+		{
+			name: "add with empty nickname",
+			call: func() error {
+				_, err := s.AddUser(context.Background(), &api.AddUserRequest{
+					FirstName: "fn3", LastName: "ln3", Email: "third@example.com",
+				})
+
+				return err
+			},
+		},
+		{
+			name: "update to same email",
+			call: func() error {
+				email := "Me@example.com"
+				_, err := s.UpdateUser(context.Background(), &api.UpdateUserRequest{Id: other.Id, Email: &email})
+
+				return err
+			},
+			wantField: "email",
+		},
+		{
+			name: "update to same nickname",
+			call: func() error {
+				nickname := "nick1"
+				_, err := s.UpdateUser(context.Background(), &api.UpdateUserRequest{Id: other.Id, Nickname: &nickname})
+
+				return err
+			},
+			wantField: "nickname",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+			if status.Code(err) != codes.AlreadyExists {
+				t.Fatalf("unexpected error, want AlreadyExists, got: %v", err)
+			}
+			if field := conflictingField(err); field != tt.wantField {
+				t.Errorf("conflicting field = %q, want %q", field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestMigrate_NonUniqueNickname(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	if err = app.Migrate(db, false); err != nil {
+		t.Fatalf("Migrate() unexpected error: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{}, app.WithPasswordHasher(makeTestHasher()))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err = s.AddUser(ctx, &api.AddUserRequest{
+			FirstName: "fn", LastName: "ln", Email: "some_" + strconv.Itoa(i) + "@example.com", Nickname: "nick",
+			Password: "password_" + strconv.Itoa(i),
+		})
+		if err != nil {
+			t.Errorf("unexpected error on call add user: %v", err)
+		}
+	}
+
+	// a shared nickname can't tell which user is looked up.
+	_, err = s.GetUser(ctx, &api.GetUserRequest{Selector: &api.GetUserRequest_Nickname{Nickname: "nick"}})
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonAmbiguousNickname {
+		t.Errorf("GetUser() by shared nickname: want AMBIGUOUS_NICKNAME, got: %v", err)
+	}
+	_, err = s.Authenticate(ctx, &api.AuthenticateRequest{
+		Login: &api.AuthenticateRequest_Nickname{Nickname: "nick"}, Password: "password_0",
+	})
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetReason() != app.ReasonInvalidCredentials {
+		t.Errorf("Authenticate() by shared nickname: want INVALID_CREDENTIALS, got: %v", err)
+	}
+	var failures int64
+	if err = db.Model(&app.User{}).Where("failed_logins <> 0").Count(&failures).Error; err != nil || failures != 0 {
+		t.Errorf("Authenticate() by shared nickname recorded failed logins: %d, %v", failures, err)
+	}
+}
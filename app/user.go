@@ -0,0 +1,80 @@
+package app
+
+import (
+	"time"
+
+	"github.com/sir-hassan/grpc-service-user/api"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// User is the stored user model. It holds sensitive fields, so it must never be sent as is to other systems:
+// PublicUser and toAPIUser are the projections to use for notifier payloads and api replies.
+type User struct {
+	ID        string
+	FirstName string
+	LastName  string
+
+	Nickname string
+	Password string `json:"-"`
+	Email    string
+	Country  string
+
+	// Incremented on every update, for optimistic concurrency control.
+	Version int64 `gorm:"not null;default:1"`
+
+	// Brute force protection state of Authenticate.
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Set on soft deleted users, which gorm then excludes from all the queries unless they are unscoped.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// PublicUser is the projection of User that's safe to share with other systems.
+type PublicUser struct {
+	ID        string
+	FirstName string
+	LastName  string
+
+	Nickname string
+	Email    string
+	Country  string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (u *User) Public() *PublicUser {
+	return &PublicUser{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Nickname:  u.Nickname,
+		Email:     u.Email,
+		Country:   u.Country,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+func toAPIUser(u *User) *api.User {
+	apiUser := &api.User{
+		Id:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Nickname:  u.Nickname,
+		Email:     u.Email,
+		Country:   u.Country,
+		Version:   u.Version,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		UpdatedAt: timestamppb.New(u.UpdatedAt),
+	}
+	if u.DeletedAt.Valid {
+		apiUser.DeletedAt = timestamppb.New(u.DeletedAt.Time)
+	}
+
+	return apiUser
+}
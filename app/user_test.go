@@ -0,0 +1,121 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type mockListUsersServer struct {
+	grpc.ServerStream
+	users  []*api.User
+	header metadata.MD
+}
+
+func (m *mockListUsersServer) SetHeader(md metadata.MD) error {
+	m.header = metadata.Join(m.header, md)
+
+	return nil
+}
+
+func (m *mockListUsersServer) Send(u *api.User) error {
+	m.users = append(m.users, u)
+
+	return nil
+}
+
+func (m *mockListUsersServer) Context() context.Context {
+	return context.Background()
+}
+
+func TestUserStore_PasswordNeverLeaks(t *testing.T) {
+	const password = "very_secret_password"
+
+	lock := &sync.Mutex{}
+	var webHookBodies []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		data, _ := io.ReadAll(r.Body)
+		webHookBodies = append(webHookBodies, string(data))
+	}))
+	defer svr.Close()
+
+	logs := &bytes.Buffer{}
+	lg := zerolog.New(logs).Level(zerolog.DebugLevel)
+
+	webHooks := []app.WebHook{{URL: svr.URL}}
+	notifier := app.NewHTTPNotifier(lg, http.DefaultClient, webHooks, 10, app.DefaultRetryPolicy(), nil)
+	cancelNotifierChan := make(chan any)
+	doneNotifierChan := notifier.Start(cancelNotifierChan)
+
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, notifier, lg, app.WithPasswordHasher(makeTestHasher()))
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		FirstName: "fn1",
+		LastName:  "ln1",
+		Email:     "me@example.com",
+		Password:  password,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+	newPassword := password + "_updated"
+	_, err = s.UpdateUser(context.Background(), &api.UpdateUserRequest{Id: added.Id, Password: &newPassword})
+	if err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+
+	stream := &mockListUsersServer{}
+	if err = s.ListUsers(&api.ListUsersRequest{}, stream); err != nil {
+		t.Fatalf("unexpected error on call list users: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	close(cancelNotifierChan)
+	<-doneNotifierChan
+
+	stored := app.User{}
+	db.First(&stored, "id = ?", added.Id)
+
+	var wire []string
+	for _, u := range stream.users {
+		data, _ := protojson.Marshal(u)
+		wire = append(wire, string(data))
+	}
+	lock.Lock()
+	wire = append(wire, webHookBodies...)
+	lock.Unlock()
+	wire = append(wire, logs.String())
+
+	if len(stream.users) != 1 {
+		t.Errorf("ListUsers() sent %d users, want 1", len(stream.users))
+	}
+	if len(webHookBodies) != 2 {
+		t.Errorf("unexpected webhook calls count: %d", len(webHookBodies))
+	}
+	for _, secret := range []string{password, stored.Password, "Password", "password"} {
+		for _, data := range wire {
+			if strings.Contains(data, secret) {
+				t.Errorf("%q leaked in: %s", secret, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// Length limits of the user fields, in characters.
+const (
+	maxNameLength     = 100
+	minNicknameLength = 3
+	maxNicknameLength = 32
+	maxEmailLength    = 254
+	maxPasswordLength = 1024
+)
+
+// userValidator validates and normalizes the user fields of a request, collecting every violation so that they can
+// all be reported at once.
+type userValidator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+func (v *userValidator) addViolation(field string, description string) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+}
+
+// err returns an InvalidArgument error listing all the violations, or nil if there are none.
+func (v *userValidator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+
+	return fieldViolationsError(v.violations)
+}
+
+// text normalizes a free text field to NFC without surrounding spaces, and checks its length and that it has no
+// control characters. It reports whether the field is set and valid.
+func (v *userValidator) text(field string, value *string, required bool, maxLength int) bool {
+	*value = strings.TrimSpace(norm.NFC.String(*value))
+
+	if *value == "" {
+		if required {
+			v.addViolation(field, "required")
+		}
+
+		return false
+	}
+	if utf8.RuneCountInString(*value) > maxLength {
+		v.addViolation(field, fmt.Sprintf("longer than %d characters", maxLength))
+
+		return false
+	}
+	for _, r := range *value {
+		if unicode.IsControl(r) {
+			v.addViolation(field, "contains control characters")
+
+			return false
+		}
+	}
+
+	return true
+}
+
+func (v *userValidator) name(field string, value *string) {
+	v.text(field, value, true, maxNameLength)
+}
+
+// nickname checks an optional nickname made of ascii letters, digits, '_', '.' and '-'.
+func (v *userValidator) nickname(value *string) {
+	*value = strings.TrimSpace(*value)
+	if *value == "" {
+		return
+	}
+
+	if len(*value) < minNicknameLength || len(*value) > maxNicknameLength {
+		v.addViolation("nickname", fmt.Sprintf("must be %d to %d characters long", minNicknameLength, maxNicknameLength))
+
+		return
+	}
+	for _, r := range *value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-') {
+			v.addViolation("nickname", "may only contain ascii letters, digits, '_', '.' and '-'")
+
+			return
+		}
+	}
+}
+
+// email checks a required bare email address, like "me@example.com".
+func (v *userValidator) email(value *string) {
+	if !v.text("email", value, true, maxEmailLength) {
+		return
+	}
+
+	addr, err := mail.ParseAddress(*value)
+	if err != nil || addr.Address != *value || addr.Name != "" {
+		v.addViolation("email", "not a valid email address")
+
+		return
+	}
+	if at := strings.LastIndex(*value, "@"); !strings.Contains((*value)[at+1:], ".") {
+		v.addViolation("email", "not a valid email address")
+	}
+}
+
+// country checks an optional ISO 3166-1 alpha-2 country code, which gets upper cased.
+func (v *userValidator) country(value *string) {
+	*value = strings.ToUpper(strings.TrimSpace(*value))
+	if *value == "" {
+		return
+	}
+
+	if _, ok := countryCodes[*value]; !ok {
+		v.addViolation("country", "not an ISO 3166-1 alpha-2 country code")
+	}
+}
+
+// password only limits the length of passwords, as hashing very long inputs is costly.
+func (v *userValidator) password(value string) {
+	if len(value) > maxPasswordLength {
+		v.addViolation("password", fmt.Sprintf("longer than %d bytes", maxPasswordLength))
+	}
+}
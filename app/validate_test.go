@@ -0,0 +1,171 @@
+package app_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func violatedFields(err error) []string {
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+
+	return fields
+}
+
+func TestUserStore_AddUser_Validation(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	valid := func() *api.AddUserRequest {
+		return &api.AddUserRequest{FirstName: "fn", LastName: "ln", Email: "me@example.com"}
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(req *api.AddUserRequest)
+		wantFields []string
+	}{
+		{
+			name:       "missing required fields",
+			modify:     func(req *api.AddUserRequest) { req.FirstName, req.LastName, req.Email = "", " ", "" },
+			wantFields: []string{"first_name", "last_name", "email"},
+		},
+		{
+			name:       "too long name",
+			modify:     func(req *api.AddUserRequest) { req.FirstName = strings.Repeat("a", 101) },
+			wantFields: []string{"first_name"},
+		},
+		{
+			name:       "control characters",
+			modify:     func(req *api.AddUserRequest) { req.LastName = "ln\x00" },
+			wantFields: []string{"last_name"},
+		},
+		{
+			name:       "email with display name",
+			modify:     func(req *api.AddUserRequest) { req.Email = "Me <me@example.com>" },
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "email without domain",
+			modify:     func(req *api.AddUserRequest) { req.Email = "me@localhost" },
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "malformed email",
+			modify:     func(req *api.AddUserRequest) { req.Email = "me.example.com" },
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "nickname charset",
+			modify:     func(req *api.AddUserRequest) { req.Nickname = "nick name" },
+			wantFields: []string{"nickname"},
+		},
+		{
+			name:       "short nickname",
+			modify:     func(req *api.AddUserRequest) { req.Nickname = "ab" },
+			wantFields: []string{"nickname"},
+		},
+		{
+			name:       "unknown country",
+			modify:     func(req *api.AddUserRequest) { req.Country = "Germany" },
+			wantFields: []string{"country"},
+		},
+		{
+			name:       "too long password",
+			modify:     func(req *api.AddUserRequest) { req.Password = strings.Repeat("p", 1025) },
+			wantFields: []string{"password"},
+		},
+		{
+			name: "every field",
+			modify: func(req *api.AddUserRequest) {
+				*req = api.AddUserRequest{Nickname: "?", Country: "XX"}
+			},
+			wantFields: []string{"first_name", "last_name", "nickname", "email", "country"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.modify(req)
+
+			_, err := s.AddUser(context.Background(), req)
+			if status.Code(err) != codes.InvalidArgument || errorReason(err) != app.ReasonInvalidArgument {
+				t.Fatalf("unexpected error, want InvalidArgument, got: %v", err)
+			}
+			if fields := violatedFields(err); !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("violated fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestUserStore_AddUser_Normalization(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+
+	added, err := s.AddUser(context.Background(), &api.AddUserRequest{
+		// decomposed "é", to be composed by the NFC normalization.
+		FirstName: " René ",
+		LastName:  "ln",
+		Nickname:  " nick ",
+		Email:     " me@example.com",
+		Country:   "de",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on call add user: %v", err)
+	}
+
+	reply, err := s.GetUser(context.Background(), &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: added.Id}})
+	if err != nil {
+		t.Fatalf("unexpected error on call get user: %v", err)
+	}
+	u := reply.User
+	if u.FirstName != "René" || u.Nickname != "nick" || u.Email != "me@example.com" || u.Country != "DE" {
+		t.Errorf("AddUser() stored unnormalized fields: %v", u)
+	}
+}
+
+func TestUserStore_UpdateUser_Validation(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	id := makeUser(t, s, "me@example.com")
+
+	empty, email, country, nickname := "", "not an email", "FR", ""
+	_, err = s.UpdateUser(context.Background(), &api.UpdateUserRequest{
+		Id:        id,
+		FirstName: &empty,
+		Email:     &email,
+		Country:   &country,
+		Nickname:  &nickname,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unexpected error, want InvalidArgument, got: %v", err)
+	}
+	if fields := violatedFields(err); !reflect.DeepEqual(fields, []string{"first_name", "email"}) {
+		t.Errorf("violated fields = %v, want [first_name email]", fields)
+	}
+}
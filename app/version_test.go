@@ -0,0 +1,107 @@
+package app_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sir-hassan/grpc-service-user/api"
+	"github.com/sir-hassan/grpc-service-user/app"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func userVersion(t *testing.T, s *app.UserStore, id string) int64 {
+	t.Helper()
+
+	reply, err := s.GetUser(context.Background(), &api.GetUserRequest{Selector: &api.GetUserRequest_Id{Id: id}})
+	if err != nil {
+		t.Fatalf("unexpected error on call get user: %v", err)
+	}
+
+	return reply.User.Version
+}
+
+func TestUserStore_ExpectedVersion(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	id := makeUser(t, s, "me@example.com")
+	ctx := context.Background()
+
+	if v := userVersion(t, s, id); v != 1 {
+		t.Fatalf("AddUser() version = %d, want 1", v)
+	}
+
+	country := "DE"
+	if _, err = s.UpdateUser(ctx, &api.UpdateUserRequest{Id: id, Country: &country}); err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	if v := userVersion(t, s, id); v != 2 {
+		t.Fatalf("UpdateUser() version = %d, want 2", v)
+	}
+
+	country = "FR"
+	_, err = s.UpdateUser(ctx, &api.UpdateUserRequest{Id: id, Country: &country, ExpectedVersion: 1})
+	if status.Code(err) != codes.Aborted || errorReason(err) != app.ReasonVersionMismatch {
+		t.Fatalf("UpdateUser() with stale version: want Aborted, got: %v", err)
+	}
+	if info, _ := errorDetail[*errdetails.ErrorInfo](err); info.GetMetadata()["current_version"] != "2" {
+		t.Errorf("UpdateUser() unexpected error info: %v", info)
+	}
+	if _, err = s.UpdateUser(ctx, &api.UpdateUserRequest{Id: id, Country: &country, ExpectedVersion: 2}); err != nil {
+		t.Fatalf("unexpected error on call update user: %v", err)
+	}
+	if v := userVersion(t, s, id); v != 3 {
+		t.Fatalf("UpdateUser() version = %d, want 3", v)
+	}
+
+	_, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id, ExpectedVersion: 2})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("DeleteUser() with stale version: want Aborted, got: %v", err)
+	}
+	if _, err = s.DeleteUser(ctx, &api.DeleteUserRequest{Id: id, ExpectedVersion: 3}); err != nil {
+		t.Fatalf("unexpected error on call delete user: %v", err)
+	}
+}
+
+func TestUserStore_ConcurrentUpdates(t *testing.T) {
+	db, err := makeMockDB()
+	if err != nil {
+		t.Fatalf("create mock db: %v", err)
+	}
+	s := app.NewUserStore(db, app.NewMockedNotifier(), zerolog.Logger{})
+	id := makeUser(t, s, "me@example.com")
+
+	const writers = 10
+	codesChan := make(chan codes.Code, writers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "writer_" + strconv.Itoa(i)
+			_, err := s.UpdateUser(context.Background(), &api.UpdateUserRequest{
+				Id:              id,
+				FirstName:       &name,
+				ExpectedVersion: 1,
+			})
+			codesChan <- status.Code(err)
+		}(i)
+	}
+	wg.Wait()
+	close(codesChan)
+
+	counts := map[codes.Code]int{}
+	for code := range codesChan {
+		counts[code]++
+	}
+	if counts[codes.OK] != 1 || counts[codes.Aborted] != writers-1 {
+		t.Errorf("unexpected concurrent updates results: %v", counts)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sir-hassan/grpc-service-user/api"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func runE2eCommand(lg zerolog.Logger) {
@@ -52,6 +54,7 @@ func runE2eCommand(lg zerolog.Logger) {
 			FirstName: "user_first_name_" + strconv.Itoa(i),
 			LastName:  "user_last_name_" + strconv.Itoa(i),
 			Email:     "some_" + strconv.Itoa(i) + "@example.com",
+			Password:  "password_" + strconv.Itoa(i),
 		})
 		if err != nil {
 			lg.Fatal().Err(err).Msg("call add user")
@@ -60,6 +63,35 @@ func runE2eCommand(lg zerolog.Logger) {
 	}
 	lg.Info().Msg("✅ adding 10 users")
 
+	// authenticate users
+	for i := 0; i < 10; i++ {
+		auth, err := client.Authenticate(context.Background(), &api.AuthenticateRequest{
+			Login:    &api.AuthenticateRequest_Email{Email: "some_" + strconv.Itoa(i) + "@example.com"},
+			Password: "password_" + strconv.Itoa(i),
+		})
+		if err != nil {
+			lg.Fatal().Err(err).Msg("call authenticate")
+		}
+		if auth.Id != ids[i] {
+			lg.Fatal().Str("id", auth.Id).Msg("authenticate returned unexpected id")
+		}
+	}
+	lg.Info().Msg("✅ authenticating 10 users")
+
+	// get users
+	for i := 0; i < 10; i++ {
+		reply, err := client.GetUser(context.Background(), &api.GetUserRequest{
+			Selector: &api.GetUserRequest_Id{Id: ids[i]},
+		})
+		if err != nil {
+			lg.Fatal().Err(err).Msg("call get user")
+		}
+		if reply.User.Email != "some_"+strconv.Itoa(i)+"@example.com" {
+			lg.Fatal().Str("email", reply.User.Email).Msg("get user returned unexpected email")
+		}
+	}
+	lg.Info().Msg("✅ getting 10 users")
+
 	// update users
 	for i := 0; i < 10; i++ {
 		updatedName := "user_updated_first_name"
@@ -83,4 +115,61 @@ func runE2eCommand(lg zerolog.Logger) {
 		}
 	}
 	lg.Info().Msg("✅ deleting 10 users")
+
+	// get deleted users
+	for i := 0; i < 10; i++ {
+		_, err = client.GetUser(context.Background(), &api.GetUserRequest{
+			Selector: &api.GetUserRequest_Id{Id: ids[i]},
+		})
+		if status.Code(err) != codes.NotFound {
+			lg.Fatal().Err(err).Msg("call get user on deleted user")
+		}
+	}
+	lg.Info().Msg("✅ getting 10 deleted users")
+
+	// batch add and delete users
+	var addRequests []*api.AddUserRequest
+	for i := 0; i < 10; i++ {
+		addRequests = append(addRequests, &api.AddUserRequest{
+			FirstName: "batch_first_name_" + strconv.Itoa(i),
+			LastName:  "batch_last_name_" + strconv.Itoa(i),
+			Email:     "batch_" + strconv.Itoa(i) + "@example.com",
+		})
+	}
+	batchAdd, err := client.BatchAddUsers(context.Background(), &api.BatchAddUsersRequest{Requests: addRequests})
+	if err != nil {
+		lg.Fatal().Err(err).Msg("call batch add users")
+	}
+	var deleteRequests []*api.DeleteUserRequest
+	for _, result := range batchAdd.Results {
+		deleteRequests = append(deleteRequests, &api.DeleteUserRequest{Id: result.User.Id})
+	}
+	_, err = client.BatchDeleteUsers(context.Background(), &api.BatchDeleteUsersRequest{Requests: deleteRequests})
+	if err != nil {
+		lg.Fatal().Err(err).Msg("call batch delete users")
+	}
+	lg.Info().Msg("✅ batch adding and deleting 10 users")
+
+	// retry adding a user with an idempotency key
+	addRequest := &api.AddUserRequest{
+		FirstName:      "idempotent_first_name",
+		LastName:       "idempotent_last_name",
+		Email:          "idempotent@example.com",
+		IdempotencyKey: "e2e-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	var addedIDs []string
+	for i := 0; i < 2; i++ {
+		u, err := client.AddUser(context.Background(), addRequest)
+		if err != nil {
+			lg.Fatal().Err(err).Msg("call add user with idempotency key")
+		}
+		addedIDs = append(addedIDs, u.Id)
+	}
+	if addedIDs[0] != addedIDs[1] {
+		lg.Fatal().Strs("ids", addedIDs).Msg("retried add user returned another user")
+	}
+	if _, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: addedIDs[0]}); err != nil {
+		lg.Fatal().Err(err).Msg("call delete user")
+	}
+	lg.Info().Msg("✅ retrying adding a user with an idempotency key")
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sir-hassan/grpc-service-user/api"
 	"github.com/sir-hassan/grpc-service-user/app"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"gorm.io/driver/postgres"
@@ -21,7 +23,7 @@ import (
 
 const (
 	// HTTPNotifier implements asynchronous processing of webhook in a fifo queue manner. This constant sets the size
-	// of that queue.
+	// of the queue of every webhook.
 	httpNotifierQueueSize = 1000
 )
 
@@ -34,7 +36,65 @@ type envVars struct {
 	PostgresPassword string `env:"POSTGRES_PASSWORD" envDefault:"password"`
 	PostgresDB       string `env:"POSTGRES_DB" envDefault:"userdb"`
 
-	NotifierWebHooks []string `env:"NOTIFIER_WEBHOOKS" envSeparator:","`
+	NotifierWebHooks       []string      `env:"NOTIFIER_WEBHOOKS" envSeparator:","`
+	NotifierWebHookSecrets []string      `env:"NOTIFIER_WEBHOOK_SECRETS" envSeparator:","`
+	NotifierWebHookFormats []string      `env:"NOTIFIER_WEBHOOK_FORMATS" envSeparator:","`
+	NotifierTimeout        time.Duration `env:"NOTIFIER_TIMEOUT" envDefault:"10s"`
+	NotifierMaxAttempts    int           `env:"NOTIFIER_MAX_ATTEMPTS" envDefault:"5"`
+	NotifierInitialBackoff time.Duration `env:"NOTIFIER_INITIAL_BACKOFF" envDefault:"500ms"`
+	NotifierMaxBackoff     time.Duration `env:"NOTIFIER_MAX_BACKOFF" envDefault:"30s"`
+
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM" envDefault:"argon2id"`
+	Argon2Time            uint32 `env:"ARGON2_TIME" envDefault:"3"`
+	Argon2MemoryKiB       uint32 `env:"ARGON2_MEMORY_KIB" envDefault:"65536"`
+	Argon2Threads         uint8  `env:"ARGON2_THREADS" envDefault:"4"`
+	BcryptCost            int    `env:"BCRYPT_COST" envDefault:"12"`
+
+	AuthMaxFailedAttempts int           `env:"AUTH_MAX_FAILED_ATTEMPTS" envDefault:"5"`
+	AuthLockoutDuration   time.Duration `env:"AUTH_LOCKOUT_DURATION" envDefault:"15m"`
+	AuthRateLimit         int           `env:"AUTH_RATE_LIMIT" envDefault:"10"`
+	AuthRateLimitWindow   time.Duration `env:"AUTH_RATE_LIMIT_WINDOW" envDefault:"1m"`
+
+	PageTokenSecret string `env:"PAGE_TOKEN_SECRET"`
+
+	UniqueNickname bool `env:"UNIQUE_NICKNAME" envDefault:"true"`
+
+	DeletedUserRetention time.Duration `env:"DELETED_USER_RETENTION" envDefault:"720h"`
+	PurgeInterval        time.Duration `env:"PURGE_INTERVAL" envDefault:"1h"`
+
+	IdempotencyKeyTTL        time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+	IdempotencySecret        string        `env:"IDEMPOTENCY_SECRET"`
+	IdempotencyPurgeInterval time.Duration `env:"IDEMPOTENCY_PURGE_INTERVAL" envDefault:"1h"`
+
+	OutboxEnabled      bool          `env:"OUTBOX_ENABLED" envDefault:"true"`
+	OutboxPollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL" envDefault:"1s"`
+	OutboxClaimLease   time.Duration `env:"OUTBOX_CLAIM_LEASE" envDefault:"15m"`
+	OutboxRetention    time.Duration `env:"OUTBOX_RETENTION" envDefault:"168h"`
+}
+
+// String returns the config with its secrets redacted, so that it can be logged.
+func (c envVars) String() string {
+	// plainEnvVars has no String method, so that formatting it doesn't recurse.
+	type plainEnvVars envVars
+	redacted := plainEnvVars(c)
+	redacted.PostgresPassword = redactedSecret(c.PostgresPassword)
+	redacted.PageTokenSecret = redactedSecret(c.PageTokenSecret)
+	redacted.IdempotencySecret = redactedSecret(c.IdempotencySecret)
+	redacted.NotifierWebHookSecrets = make([]string, len(c.NotifierWebHookSecrets))
+	for i, secrets := range c.NotifierWebHookSecrets {
+		redacted.NotifierWebHookSecrets[i] = redactedSecret(secrets)
+	}
+
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// redactedSecret returns a placeholder of secret, telling whether it's set.
+func redactedSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return "REDACTED"
 }
 
 func runServerCommand(lg zerolog.Logger) {
@@ -42,20 +102,28 @@ func runServerCommand(lg zerolog.Logger) {
 	if err := env.Parse(&cfg); err != nil {
 		lg.Fatal().Err(err).Msg("couldn't parse env variables")
 	}
-	lg.Debug().Str("env_vars", fmt.Sprintf("%+v", cfg)).Msg("calculated env vars")
+	lg.Debug().Stringer("env_vars", cfg).Msg("calculated env vars")
 
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable TimeZone=Europe/Berlin",
-		cfg.PostgresHost, cfg.PostgresPort, cfg.PostgresUser, cfg.PostgresPassword, cfg.PostgresDB,
-	)
-	lg.Debug().Str("dsn", dsn).Msg("calculated postgres dns string")
+	dsn := postgresDSN(cfg, cfg.PostgresPassword)
+	lg.Debug().Str("dsn", postgresDSN(cfg, redactedSecret(cfg.PostgresPassword))).Msg("calculated postgres dns string")
 
-	db, err := newGormDB(dsn, lg)
+	hasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("invalid password hashing config")
+	}
+
+	db, err := newGormDB(dsn, cfg.UniqueNickname, lg)
 	if err != nil {
 		lg.Fatal().Err(err).Msg("connecting to database failed")
 	}
 	lg.Info().Msg("connected to database")
 
+	migrated, err := app.HashPlaintextPasswords(db, hasher)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("hashing plaintext passwords failed")
+	}
+	lg.Info().Int("count", migrated).Msg("hashed plaintext passwords")
+
 	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
 	if err != nil {
 		lg.Fatal().Err(err).Msg("tcp listen")
@@ -63,17 +131,80 @@ func runServerCommand(lg zerolog.Logger) {
 
 	lg.Info().Int("port", cfg.Port).Msg("start tcp listener")
 
-	notifier := app.NewHTTPNotifier(lg, http.DefaultClient, cfg.NotifierWebHooks, httpNotifierQueueSize)
+	retryPolicy := app.DefaultRetryPolicy()
+	retryPolicy.MaxAttempts = cfg.NotifierMaxAttempts
+	retryPolicy.InitialBackoff = cfg.NotifierInitialBackoff
+	retryPolicy.MaxBackoff = cfg.NotifierMaxBackoff
+	httpClient := &http.Client{Timeout: cfg.NotifierTimeout}
+	webHooks, err := newWebHooks(cfg)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("invalid webhooks config")
+	}
+	deadLetters := app.NewDeadLetterStore(db)
+	notifier := app.NewHTTPNotifier(lg, httpClient, webHooks, httpNotifierQueueSize, retryPolicy, deadLetters)
 
 	cancelNotifierChan := make(chan any)
 	doneNotifierChan := notifier.Start(cancelNotifierChan)
 
-	store := app.NewUserStore(db, notifier, lg)
+	storeOpts := []app.UserStoreOption{
+		app.WithPasswordHasher(hasher),
+		app.WithAuthPolicy(app.AuthPolicy{
+			MaxFailedAttempts: cfg.AuthMaxFailedAttempts,
+			LockoutDuration:   cfg.AuthLockoutDuration,
+			RateLimit:         cfg.AuthRateLimit,
+			RateLimitWindow:   cfg.AuthRateLimitWindow,
+		}),
+		app.WithIdempotencyKeyTTL(cfg.IdempotencyKeyTTL),
+	}
+	if cfg.PageTokenSecret != "" {
+		storeOpts = append(storeOpts, app.WithPageTokenSecret([]byte(cfg.PageTokenSecret)))
+	}
+	if cfg.IdempotencySecret != "" {
+		storeOpts = append(storeOpts, app.WithIdempotencySecret([]byte(cfg.IdempotencySecret)))
+	}
+	if cfg.OutboxEnabled {
+		storeOpts = append(storeOpts, app.WithOutbox())
+	}
+	store := app.NewUserStore(db, notifier, lg, storeOpts...)
+
+	// Without the outbox, notifications are only queued in memory by the notifier.
+	cancelDispatcherChan := make(chan any)
+	doneDispatcherChan := make(chan any)
+	if cfg.OutboxEnabled {
+		// the claim of an event must outlive its delivery, or other instances deliver it again meanwhile.
+		if maxDelivery := notifier.MaxDeliveryTime(); cfg.OutboxClaimLease <= maxDelivery {
+			lg.Fatal().Dur("lease", cfg.OutboxClaimLease).Dur("max_delivery_time", maxDelivery).
+				Msg("OUTBOX_CLAIM_LEASE must exceed the max delivery time of an event to the webhooks")
+		}
+		dispatcher := app.NewOutboxDispatcher(
+			lg, db, notifier, cfg.OutboxPollInterval, cfg.OutboxClaimLease, cfg.OutboxRetention,
+		)
+		doneDispatcherChan = dispatcher.Start(cancelDispatcherChan)
+	} else {
+		close(doneDispatcherChan)
+	}
+
+	// A zero retention keeps deleted users until they are purged with PurgeUser.
+	cancelPurgerChan := make(chan any)
+	donePurgerChan := make(chan any)
+	if cfg.DeletedUserRetention > 0 {
+		donePurgerChan = app.NewPurger(lg, store, cfg.DeletedUserRetention, cfg.PurgeInterval).Start(cancelPurgerChan)
+	} else {
+		close(donePurgerChan)
+	}
+
+	cancelIdempotencyPurgerChan := make(chan any)
+	doneIdempotencyPurgerChan := app.NewIdempotencyRecordPurger(lg, store, cfg.IdempotencyPurgeInterval).
+		Start(cancelIdempotencyPurgerChan)
 
-	var opts []grpc.ServerOption
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(app.UnaryRequestIDInterceptor),
+		grpc.ChainStreamInterceptor(app.StreamRequestIDInterceptor),
+	}
 	grpcServer := grpc.NewServer(opts...)
 	reflection.Register(grpcServer)
 	api.RegisterUserStoreServer(grpcServer, store)
+	api.RegisterDeadLetterAdminServer(grpcServer, app.NewDeadLetterAdmin(lg, deadLetters, notifier))
 
 	// Handle process termination.
 	sigChan := make(chan os.Signal, 1)
@@ -84,6 +215,9 @@ func runServerCommand(lg zerolog.Logger) {
 		lg.Info().Str("sig", sig.String()).Msg("signal received")
 		lg.Info().Msg("terminating server...")
 		grpcServer.GracefulStop()
+		close(cancelPurgerChan)
+		close(cancelIdempotencyPurgerChan)
+		close(cancelDispatcherChan)
 		close(cancelNotifierChan)
 	}()
 
@@ -93,13 +227,93 @@ func runServerCommand(lg zerolog.Logger) {
 		lg.Fatal().Err(err).Msg("serve grpc")
 	}
 
+	lg.Info().Msg("waiting to terminate purger")
+	<-donePurgerChan
+
+	lg.Info().Msg("waiting to terminate idempotency record purger")
+	<-doneIdempotencyPurgerChan
+
+	lg.Info().Msg("waiting to terminate outbox dispatcher")
+	<-doneDispatcherChan
+
 	lg.Info().Msg("waiting to terminate notifier")
 	<-doneNotifierChan
 
 	lg.Info().Msg("server terminated successfully")
 }
 
-func newGormDB(dsn string, lg zerolog.Logger) (*gorm.DB, error) {
+// newPasswordHasher returns a hasher that hashes new passwords with the configured algorithm, while still verifying
+// hashes produced by the other one, so switching algorithms upgrades stored hashes on the next successful login. The
+// parameters of both algorithms are validated: argon2 panics on too low ones, while bcrypt silently raises a too low
+// cost, which would then flag every hash for a rehash.
+func newPasswordHasher(cfg envVars) (*app.PasswordHasher, error) {
+	switch {
+	case cfg.Argon2Time < 1:
+		return nil, fmt.Errorf("ARGON2_TIME must be at least 1, got %d", cfg.Argon2Time)
+	case cfg.Argon2Threads < 1:
+		return nil, fmt.Errorf("ARGON2_THREADS must be at least 1, got %d", cfg.Argon2Threads)
+	case cfg.Argon2MemoryKiB < 8*uint32(cfg.Argon2Threads):
+		return nil, fmt.Errorf("ARGON2_MEMORY_KIB must be at least 8 times ARGON2_THREADS, got %d", cfg.Argon2MemoryKiB)
+	case cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost:
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost,
+			cfg.BcryptCost)
+	}
+
+	argon2idAlgorithm := app.NewArgon2idAlgorithm(cfg.Argon2Time, cfg.Argon2MemoryKiB, cfg.Argon2Threads)
+	bcryptAlgorithm := app.NewBcryptAlgorithm(cfg.BcryptCost)
+
+	switch cfg.PasswordHashAlgorithm {
+	case "argon2id":
+		return app.NewPasswordHasher(argon2idAlgorithm, bcryptAlgorithm), nil
+	case "bcrypt":
+		return app.NewPasswordHasher(bcryptAlgorithm, argon2idAlgorithm), nil
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm '%s'", cfg.PasswordHashAlgorithm)
+	}
+}
+
+// newWebHooks returns the configured webhooks along with their secrets and formats. NOTIFIER_WEBHOOK_SECRETS holds the
+// secrets of every webhook, in the order of NOTIFIER_WEBHOOKS, as space separated lists: "<secret>,<new secret> <old
+// secret>". Webhooks without secrets aren't signed. NOTIFIER_WEBHOOK_FORMATS holds their payload formats in the same
+// order, webhooks without a format getting the legacy one.
+func newWebHooks(cfg envVars) ([]app.WebHook, error) {
+	if len(cfg.NotifierWebHookSecrets) > len(cfg.NotifierWebHooks) {
+		return nil, fmt.Errorf("%d webhook secrets for %d webhooks", len(cfg.NotifierWebHookSecrets),
+			len(cfg.NotifierWebHooks))
+	}
+	if len(cfg.NotifierWebHookFormats) > len(cfg.NotifierWebHooks) {
+		return nil, fmt.Errorf("%d webhook formats for %d webhooks", len(cfg.NotifierWebHookFormats),
+			len(cfg.NotifierWebHooks))
+	}
+
+	webHooks := make([]app.WebHook, len(cfg.NotifierWebHooks))
+	for i, url := range cfg.NotifierWebHooks {
+		webHooks[i].URL = url
+		if i < len(cfg.NotifierWebHookSecrets) {
+			for _, secret := range strings.Fields(cfg.NotifierWebHookSecrets[i]) {
+				webHooks[i].Secrets = append(webHooks[i].Secrets, []byte(secret))
+			}
+		}
+		if i < len(cfg.NotifierWebHookFormats) && strings.TrimSpace(cfg.NotifierWebHookFormats[i]) != "" {
+			format, err := app.ParsePayloadFormat(strings.TrimSpace(cfg.NotifierWebHookFormats[i]))
+			if err != nil {
+				return nil, err
+			}
+			webHooks[i].Format = format
+		}
+	}
+
+	return webHooks, nil
+}
+
+func postgresDSN(cfg envVars, password string) string {
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable TimeZone=Europe/Berlin",
+		cfg.PostgresHost, cfg.PostgresPort, cfg.PostgresUser, password, cfg.PostgresDB,
+	)
+}
+
+func newGormDB(dsn string, uniqueNickname bool, lg zerolog.Logger) (*gorm.DB, error) {
 	var err error
 	var db *gorm.DB
 
@@ -120,7 +334,7 @@ func newGormDB(dsn string, lg zerolog.Logger) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&app.User{})
+	err = app.Migrate(db, uniqueNickname)
 	if err != nil {
 		return nil, err
 	}
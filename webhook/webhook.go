@@ -0,0 +1,215 @@
+// Package webhook signs the webhook requests sent by the user service, and lets webhook receivers verify them.
+//
+// Signed requests carry a SignatureHeader like "t=1666000000,v1=5257a8...,v1=9f86d0...", where t is the unix time of
+// the signature and every v1 is the hex encoded HMAC-SHA256 of "<t>\n<id>\n<type>\n<subject>\n<body>" with one of the
+// active secrets. The event id, type and subject are sent in the IDHeader, TypeHeader and SubjectHeader, and are signed
+// along with the body so that a captured request can't be replayed as another event, or another action on the user.
+// The type is the action of the url path in the legacy format ("update" of "<webhook>/update"), and the CloudEvents
+// type ("user.updated") otherwise. VerifyRequest rejects the requests whose url path action, CloudEvents "ce-*"
+// headers or structured event don't match the signed attributes, the check being picked from the signed type.
+//
+// Requests are signed with all the active secrets, so that secrets can be rotated without downtime: the new secret is
+// added to the sender, then receivers switch to it, and the old one is removed from the sender. Receivers reject the
+// requests whose timestamp is too far from their clock, so that captured requests can't be replayed later on.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader is the header holding the signatures of a webhook request.
+	SignatureHeader = "Webhook-Signature"
+	// IDHeader is the header holding the signed id of the event, kept across retries and replays.
+	IDHeader = "Webhook-Id"
+	// TypeHeader is the header holding the signed type of the event.
+	TypeHeader = "Webhook-Type"
+	// SubjectHeader is the header holding the signed subject of the event, the id of the changed user.
+	SubjectHeader = "Webhook-Subject"
+	// DefaultTolerance is the recommended max difference between the timestamp of a request and the receiver clock.
+	DefaultTolerance = 5 * time.Minute
+
+	timestampKey    = "t"
+	signatureScheme = "v1"
+
+	cloudEventsStructuredContent = "application/cloudevents+json"
+	cloudEventsTypePrefix        = "user."
+)
+
+var (
+	// ErrMissingSignature is returned when a request has no SignatureHeader.
+	ErrMissingSignature = errors.New("webhook: missing signature")
+	// ErrInvalidSignature is returned when the SignatureHeader can't be parsed.
+	ErrInvalidSignature = errors.New("webhook: invalid signature header")
+	// ErrTimestampOutOfTolerance is returned when a request was signed too long ago, or too far in the future.
+	ErrTimestampOutOfTolerance = errors.New("webhook: timestamp out of tolerance")
+	// ErrSignatureMismatch is returned when no signature matches the event with any of the secrets.
+	ErrSignatureMismatch = errors.New("webhook: signature mismatch")
+	// ErrAttributeMismatch is returned when the url path action or the CloudEvents headers of a request don't match
+	// its signed event attributes.
+	ErrAttributeMismatch = errors.New("webhook: attribute mismatch")
+)
+
+// Event is the signed content of a webhook request.
+type Event struct {
+	ID      string
+	Type    string
+	Subject string
+	Body    []byte
+}
+
+// Sign returns the SignatureHeader value of event signed at timestamp with every one of secrets.
+func Sign(timestamp time.Time, event *Event, secrets ...[]byte) string {
+	unix := timestamp.Unix()
+
+	var header strings.Builder
+	header.WriteString(timestampKey + "=" + strconv.FormatInt(unix, 10))
+	for _, secret := range secrets {
+		header.WriteString("," + signatureScheme + "=" + hex.EncodeToString(signature(secret, unix, event)))
+	}
+
+	return header.String()
+}
+
+// Verify checks that header holds a signature of event with one of secrets, made within tolerance of the current time.
+// A non-positive tolerance disables the timestamp check, leaving requests open to replays.
+func Verify(header string, event *Event, tolerance time.Duration, secrets ...[]byte) error {
+	if header == "" {
+		return ErrMissingSignature
+	}
+	unix, signatures, err := parseHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if tolerance > 0 {
+		if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+			return ErrTimestampOutOfTolerance
+		}
+	}
+	for _, secret := range secrets {
+		expected := signature(secret, unix, event)
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				return nil
+			}
+		}
+	}
+
+	return ErrSignatureMismatch
+}
+
+// VerifyRequest reads the body of r and verifies its signature like Verify, and that the action of its url path or its
+// CloudEvents headers match the signed attributes. It returns the signed event, whose body is also left readable in r.
+func VerifyRequest(r *http.Request, tolerance time.Duration, secrets ...[]byte) (*Event, error) {
+	body, err := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	event := &Event{
+		ID:      r.Header.Get(IDHeader),
+		Type:    r.Header.Get(TypeHeader),
+		Subject: r.Header.Get(SubjectHeader),
+		Body:    body,
+	}
+	if err = Verify(r.Header.Get(SignatureHeader), event, tolerance, secrets...); err != nil {
+		return event, err
+	}
+
+	return event, checkAttributes(r, event)
+}
+
+// checkAttributes checks that the unsigned attributes of r, which receivers may act upon, match the signed event. The
+// check is picked from the signed type, so that unsigned headers can't skip it: the CloudEvents types ("user.*") must
+// match the event of the structured body or the "ce-*" headers of the binary mode, and the legacy types must match
+// the action of the url path, on requests that don't look like CloudEvents.
+func checkAttributes(r *http.Request, event *Event) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	structured := mediaType == cloudEventsStructuredContent
+
+	if !strings.HasPrefix(event.Type, cloudEventsTypePrefix) {
+		if structured || r.Header.Get("ce-specversion") != "" || path.Base(r.URL.Path) != event.Type {
+			return ErrAttributeMismatch
+		}
+
+		return nil
+	}
+
+	var attributes struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Subject string `json:"subject"`
+	}
+	if structured {
+		if err := json.Unmarshal(event.Body, &attributes); err != nil {
+			return ErrAttributeMismatch
+		}
+	} else {
+		attributes.ID, attributes.Type = r.Header.Get("ce-id"), r.Header.Get("ce-type")
+		attributes.Subject = r.Header.Get("ce-subject")
+	}
+	if attributes.ID != event.ID || attributes.Type != event.Type || attributes.Subject != event.Subject {
+		return ErrAttributeMismatch
+	}
+
+	return nil
+}
+
+// signature returns the HMAC of event at unix with secret. The attributes are separated by newlines, which header
+// values can't hold, so that a part of one can't be moved to another.
+func signature(secret []byte, unix int64, event *Event) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(unix, 10) + "\n" + event.ID + "\n" + event.Type + "\n" + event.Subject + "\n"))
+	mac.Write(event.Body)
+
+	return mac.Sum(nil)
+}
+
+// parseHeader returns the timestamp and the signatures of header. Unknown schemes are skipped, so that new ones can be
+// rolled out.
+func parseHeader(header string) (int64, [][]byte, error) {
+	var unix int64
+	var hasTimestamp bool
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return 0, nil, ErrInvalidSignature
+		}
+
+		switch key {
+		case timestampKey:
+			var err error
+			if unix, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return 0, nil, ErrInvalidSignature
+			}
+			hasTimestamp = true
+		case signatureScheme:
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return 0, nil, ErrInvalidSignature
+			}
+			signatures = append(signatures, sig)
+		}
+	}
+	if !hasTimestamp || len(signatures) == 0 {
+		return 0, nil, ErrInvalidSignature
+	}
+
+	return unix, signatures, nil
+}
@@ -0,0 +1,140 @@
+package webhook_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sir-hassan/grpc-service-user/webhook"
+)
+
+func TestVerify(t *testing.T) {
+	event := &webhook.Event{ID: "event-1", Type: "update", Subject: "111", Body: []byte(`{"ID":"111"}`)}
+	oldSecret, newSecret := []byte("old-secret"), []byte("new-secret")
+	now := time.Now()
+
+	tampered := func(change func(e *webhook.Event)) *webhook.Event {
+		e := *event
+		change(&e)
+
+		return &e
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		event   *webhook.Event
+		secrets [][]byte
+		wantErr error
+	}{
+		{"valid", webhook.Sign(now, event, newSecret), event, [][]byte{newSecret}, nil},
+		// while rotating, requests are signed with both secrets and receivers accept either of theirs.
+		{"rotated sender", webhook.Sign(now, event, oldSecret, newSecret), event, [][]byte{newSecret}, nil},
+		{"rotated receiver", webhook.Sign(now, event, oldSecret), event, [][]byte{newSecret, oldSecret}, nil},
+		{"wrong secret", webhook.Sign(now, event, oldSecret), event, [][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"tampered body", webhook.Sign(now, event, newSecret),
+			tampered(func(e *webhook.Event) { e.Body = []byte(`{"ID":"222"}`) }), [][]byte{newSecret},
+			webhook.ErrSignatureMismatch},
+		{"tampered id", webhook.Sign(now, event, newSecret), tampered(func(e *webhook.Event) { e.ID = "event-2" }),
+			[][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"tampered type", webhook.Sign(now, event, newSecret), tampered(func(e *webhook.Event) { e.Type = "delete" }),
+			[][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"tampered subject", webhook.Sign(now, event, newSecret), tampered(func(e *webhook.Event) { e.Subject = "222" }),
+			[][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		// the attributes are delimited, so that a part of one can't be moved to another.
+		{"moved attribute", webhook.Sign(now, event, newSecret),
+			tampered(func(e *webhook.Event) { e.ID, e.Type = "event", "1\nupdate" }), [][]byte{newSecret},
+			webhook.ErrSignatureMismatch},
+		{"expired", webhook.Sign(now.Add(-time.Hour), event, newSecret), event, [][]byte{newSecret},
+			webhook.ErrTimestampOutOfTolerance},
+		{"future", webhook.Sign(now.Add(time.Hour), event, newSecret), event, [][]byte{newSecret},
+			webhook.ErrTimestampOutOfTolerance},
+		{"missing", "", event, [][]byte{newSecret}, webhook.ErrMissingSignature},
+		{"no signature", "t=1666000000", event, [][]byte{newSecret}, webhook.ErrInvalidSignature},
+		{"invalid signature", "t=1666000000,v1=xyz", event, [][]byte{newSecret}, webhook.ErrInvalidSignature},
+		{"invalid timestamp", "t=now,v1=00", event, [][]byte{newSecret}, webhook.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(tt.header, tt.event, webhook.DefaultTolerance, tt.secrets...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	secret := []byte("secret")
+	body := `{"ID":"111"}`
+	newRequest := func(target string, eventType string, body string, header map[string]string) *http.Request {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		r.Header.Set(webhook.IDHeader, "event-1")
+		r.Header.Set(webhook.TypeHeader, eventType)
+		r.Header.Set(webhook.SubjectHeader, "111")
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		event := &webhook.Event{ID: "event-1", Type: eventType, Subject: "111", Body: []byte(body)}
+		r.Header.Set(webhook.SignatureHeader, webhook.Sign(time.Now(), event, secret)+",v2=unknown")
+
+		return r
+	}
+
+	r := newRequest("/hook/update", "update", body, nil)
+	got, err := webhook.VerifyRequest(r, webhook.DefaultTolerance, secret)
+	if err != nil {
+		t.Fatalf("VerifyRequest() unexpected error: %v", err)
+	}
+	if string(got.Body) != body || got.ID != "event-1" || got.Type != "update" || got.Subject != "111" {
+		t.Errorf("VerifyRequest() event = %+v", got)
+	}
+	// the body can still be read by the handler.
+	if again, _ := io.ReadAll(r.Body); string(again) != body {
+		t.Errorf("request body after VerifyRequest() = %q, want %q", again, body)
+	}
+
+	binary := func(id string, eventType string) map[string]string {
+		return map[string]string{
+			"Content-Type": "application/json", "ce-specversion": "1.0", "ce-id": id, "ce-type": eventType,
+			"ce-subject": "111",
+		}
+	}
+	structuredContent := map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"}
+	structured := `{"specversion":"1.0","id":"event-1","type":"user.updated","subject":"111","data":{"ID":"111"}}`
+	tests := []struct {
+		name    string
+		request *http.Request
+		wantErr error
+	}{
+		// a captured request can't be replayed as another action.
+		{"legacy replayed to another action", newRequest("/hook/delete", "update", body, nil),
+			webhook.ErrAttributeMismatch},
+		// unsigned headers can't skip the check of the legacy url path.
+		{"legacy replayed with structured content type", newRequest("/hook/delete", "update", body, structuredContent),
+			webhook.ErrAttributeMismatch},
+		{"legacy replayed with binary headers", newRequest("/hook/delete", "update", body, binary("event-1", "update")),
+			webhook.ErrAttributeMismatch},
+		{"binary", newRequest("/hook", "user.updated", body, binary("event-1", "user.updated")), nil},
+		{"binary with another type", newRequest("/hook", "user.updated", body, binary("event-1", "user.deleted")),
+			webhook.ErrAttributeMismatch},
+		{"binary with another id", newRequest("/hook", "user.updated", body, binary("event-2", "user.updated")),
+			webhook.ErrAttributeMismatch},
+		{"binary without headers", newRequest("/hook/user.updated", "user.updated", body, nil),
+			webhook.ErrAttributeMismatch},
+		{"structured", newRequest("/hook", "user.updated", structured, structuredContent), nil},
+		{"structured with another event", newRequest("/hook", "user.deleted", structured, structuredContent),
+			webhook.ErrAttributeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := webhook.VerifyRequest(tt.request, webhook.DefaultTolerance, secret); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyRequest() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}